@@ -64,6 +64,7 @@ var overrideableBazelFlags []string = []string{
 	"--noenable_bzlmod",
 	"--nostamp",
 	"--output_groups=",
+	"--override_module=",
 	"--override_repository=",
 	"--platforms",
 	"--remote_cache=",
@@ -92,6 +93,35 @@ var overrideableBazelFlags []string = []string{
 
 var debounceDuration = flag.Duration("debounce", 100*time.Millisecond, "Debounce duration")
 var logToFile = flag.String("log_to_file", "-", "Log iBazel stderr to a file instead of os.Stderr")
+var orphanedProcessAction = flag.String("orphaned_process_action", "ask", "What to do with a run target left running by an iBazel that was killed: ask, kill or ignore")
+var vcsWaitTimeout = flag.Duration("vcs_wait_timeout", 0, "Maximum duration to hold builds while a git merge, rebase, cherry-pick or revert is in progress. 0 holds them until it finishes")
+var refetch = flag.Bool("refetch", false, "Fetch external repositories again when MODULE.bazel, WORKSPACE or a file they reference, such as a lockfile, changes")
+var watchDiscovery = flag.String("watch_discovery", "query", "How to find the source files to watch: query for the sources the targets depend on, or aquery for the inputs of their actions in the current configuration")
+var envFiles stringList
+var watchExtra stringList
+var ignorePatterns stringList
+var watchOnly stringList
+var watchExclude stringList
+
+func init() {
+	flag.Var(&envFiles, "env_file", "Load the environment of a run target from a dotenv file, and restart the target without rebuilding it when the file changes. Can be repeated")
+	flag.Var(&watchExtra, "watch_extra", "Watch files matching a glob that aren't inputs of the targets, as <glob>[=restart|rebuild|requery|hook:<command>]. Can be repeated")
+	flag.Var(&ignorePatterns, "ignore", "Don't watch files matching a .gitignore-style pattern, in addition to the ones in .ibazelignore. Can be repeated")
+	flag.Var(&watchOnly, "watch_only", "Only watch the files of the packages matched by comma separated label patterns, such as //src/...,//lib/.... Can be repeated")
+	flag.Var(&watchExclude, "watch_exclude", "Don't watch the files of the packages matched by comma separated label patterns, such as //third_party/.... Can be repeated")
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
 func usage() {
 	fmt.Fprintf(os.Stderr, `iBazel - Version %s
@@ -196,6 +226,18 @@ func main() {
 		log.Fatalf("Error creating iBazel: %s", err)
 	}
 	i.SetDebounceDuration(*debounceDuration)
+	i.SetOrphanedProcessAction(*orphanedProcessAction)
+	i.SetEnvFiles(envFiles)
+	i.SetIgnorePatterns(ignorePatterns)
+	i.SetRefetch(*refetch)
+	i.SetVCSWaitTimeout(*vcsWaitTimeout)
+	i.SetWatchDiscovery(*watchDiscovery)
+	if err := i.SetWatchExtra(watchExtra); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := i.SetWatchFilter(watchOnly, watchExclude); err != nil {
+		log.Fatalf("Invalid --watch_only or --watch_exclude: %v", err)
+	}
 	defer i.Cleanup()
 
 	// increase the number of files that this process can
@@ -37,6 +37,8 @@ func TestParsingArgs(t *testing.T) {
 		{[]string{"--bazelrc=/home/libsamek/bazelrc", "--nohome_rc", "--output_base=/tmp/test-output-base"}, nil, []string{"--bazelrc=/home/libsamek/bazelrc", "--nohome_rc", "--output_base=/tmp/test-output-base"}, nil, nil},
 		// Whitelisted bazel flag.
 		{[]string{"--test_output=streaming"}, nil, nil, []string{"--test_output=streaming"}, nil},
+		// Module and repository overrides.
+		{[]string{"--override_module=lib=../lib", "--override_repository=foo=/src/foo", "//my/target"}, []string{"//my/target"}, nil, []string{"--override_module=lib=../lib", "--override_repository=foo=/src/foo"}, nil},
 		// Whitelisted bazel flag, arg, and target.
 		{[]string{"--test_output=streaming", "--", "--my_program_flag"}, nil, nil,[]string{"--test_output=streaming"}, []string{"--my_program_flag"}},
 	} {
@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -137,8 +138,12 @@ type Bazel interface {
 	Query(args ...string) (*blaze_query.QueryResult, error)
 	Info() (map[string]string, *bytes.Buffer, error)
 	DumpRepoMapping(canonicalRepoName string) (map[string]string, *bytes.Buffer, error)
+	Mod(args ...string) (*bytes.Buffer, error)
 	CQuery(args ...string) (*analysis.CqueryResult, error)
+	StarlarkCQuery(expr string, args ...string) ([]string, error)
+	AQueryInputs(args ...string) ([]string, error)
 	Build(args ...string) (*bytes.Buffer, error)
+	Fetch(args ...string) (*bytes.Buffer, error)
 	Norun(args ...string) (*bytes.Buffer, error)
 	Test(args ...string) (*bytes.Buffer, error)
 	Run(args ...string) (*exec.Cmd, *bytes.Buffer, error)
@@ -296,6 +301,21 @@ func (b *bazel) DumpRepoMapping(canonicalRepoName string) (map[string]string, *b
 	return result, stderrBuffer, nil
 }
 
+// Runs a bazel mod subcommand and returns its output, such as the definitions
+// of repositories:
+//
+// res, err := b.Mod("show_repo", "@@rules_go+")
+func (b *bazel) Mod(args ...string) (*bytes.Buffer, error) {
+	b.WriteToStderr(false)
+	b.WriteToStdout(false)
+	stdoutBuffer, stderrBuffer := b.newCommand("mod", args...)
+
+	if err := b.cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderrBuffer.String()))
+	}
+	return stdoutBuffer, nil
+}
+
 // Executes a query language expression over a specified subgraph of the
 // build dependency graph.
 //
@@ -375,6 +395,175 @@ func (b *bazel) processCQuery(out []byte) (*analysis.CqueryResult, error) {
 	return &qr, nil
 }
 
+// Evaluates a Starlark expression for every configured target matched by a
+// cquery expression and returns one line of output per target. The build
+// flags are passed along so that the targets have the configuration they are
+// built in.
+//
+// For example, to get the path of the executable of //path/to/package:target
+// relative to the execution root, use:
+//
+// res, err := b.StarlarkCQuery("target.files_to_run.executable.path", "//path/to/package:target")
+func (b *bazel) StarlarkCQuery(expr string, args ...string) ([]string, error) {
+	blazeArgs := append([]string(nil), "--output=starlark", "--starlark:expr="+expr, "--color=no")
+	blazeArgs = append(blazeArgs, b.args...)
+	blazeArgs = append(blazeArgs, args...)
+
+	b.WriteToStderr(true)
+	b.WriteToStdout(false)
+	stdoutBuffer, _ := b.newCommand("cquery", blazeArgs...)
+
+	if err := b.cmd.Run(); err != nil {
+		return nil, err
+	}
+	return processStarlarkCQuery(stdoutBuffer.String()), nil
+}
+
+// Returns the execution root relative paths of the files read by the actions
+// matched by an aquery expression, leaving out the actions of tools built in
+// the exec configuration. The build flags are passed along so that the actions
+// are the ones of the current configuration.
+//
+// res, err := b.AQueryInputs("deps(//path/to/package:target)")
+func (b *bazel) AQueryInputs(args ...string) ([]string, error) {
+	blazeArgs := append([]string(nil), "--output=jsonproto", "--include_commandline=false", "--include_artifacts", "--color=no")
+	blazeArgs = append(blazeArgs, b.args...)
+	blazeArgs = append(blazeArgs, args...)
+
+	b.WriteToStderr(true)
+	b.WriteToStdout(false)
+	stdoutBuffer, _ := b.newCommand("aquery", blazeArgs...)
+
+	if err := b.cmd.Run(); err != nil {
+		return nil, err
+	}
+	return processAQueryInputs(stdoutBuffer.Bytes())
+}
+
+// aqueryID is the id of an entry of the action graph, which older versions of
+// Bazel write as a string.
+type aqueryID string
+
+func (id *aqueryID) UnmarshalJSON(data []byte) error {
+	*id = aqueryID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// actionGraph is the jsonproto form of analysis.ActionGraphContainer. Newer
+// versions of Bazel replace the exec paths of artifacts with path fragments.
+type actionGraph struct {
+	Artifacts []struct {
+		ID             aqueryID `json:"id"`
+		ExecPath       string   `json:"execPath"`
+		PathFragmentID aqueryID `json:"pathFragmentId"`
+	} `json:"artifacts"`
+	Actions []struct {
+		ConfigurationID aqueryID   `json:"configurationId"`
+		InputDepSetIDs  []aqueryID `json:"inputDepSetIds"`
+	} `json:"actions"`
+	DepSetOfFiles []struct {
+		ID                  aqueryID   `json:"id"`
+		TransitiveDepSetIDs []aqueryID `json:"transitiveDepSetIds"`
+		DirectArtifactIDs   []aqueryID `json:"directArtifactIds"`
+	} `json:"depSetOfFiles"`
+	Configuration []struct {
+		ID     aqueryID `json:"id"`
+		IsTool bool     `json:"isTool"`
+	} `json:"configuration"`
+	PathFragments []struct {
+		ID       aqueryID `json:"id"`
+		Label    string   `json:"label"`
+		ParentID aqueryID `json:"parentId"`
+	} `json:"pathFragments"`
+}
+
+func processAQueryInputs(out []byte) ([]string, error) {
+	var graph actionGraph
+	if err := json.Unmarshal(out, &graph); err != nil {
+		return nil, fmt.Errorf("could not read aquery response: %w", err)
+	}
+
+	tools := map[aqueryID]bool{}
+	for _, c := range graph.Configuration {
+		tools[c.ID] = c.IsTool
+	}
+	depSets := map[aqueryID]int{}
+	for i, d := range graph.DepSetOfFiles {
+		depSets[d.ID] = i
+	}
+	fragments := map[aqueryID]int{}
+	for i, f := range graph.PathFragments {
+		fragments[f.ID] = i
+	}
+	var fragmentPath func(id aqueryID) string
+	fragmentPath = func(id aqueryID) string {
+		i, ok := fragments[id]
+		if !ok {
+			return ""
+		}
+		f := graph.PathFragments[i]
+		if parent := fragmentPath(f.ParentID); parent != "" {
+			return parent + "/" + f.Label
+		}
+		return f.Label
+	}
+	paths := map[aqueryID]string{}
+	for _, a := range graph.Artifacts {
+		if a.ExecPath != "" {
+			paths[a.ID] = a.ExecPath
+		} else {
+			paths[a.ID] = fragmentPath(a.PathFragmentID)
+		}
+	}
+
+	inputs := map[string]struct{}{}
+	visited := map[aqueryID]struct{}{}
+	var visit func(id aqueryID)
+	visit = func(id aqueryID) {
+		if _, ok := visited[id]; ok {
+			return
+		}
+		visited[id] = struct{}{}
+		i, ok := depSets[id]
+		if !ok {
+			return
+		}
+		for _, artifact := range graph.DepSetOfFiles[i].DirectArtifactIDs {
+			if path := paths[artifact]; path != "" {
+				inputs[path] = struct{}{}
+			}
+		}
+		for _, transitive := range graph.DepSetOfFiles[i].TransitiveDepSetIDs {
+			visit(transitive)
+		}
+	}
+	for _, action := range graph.Actions {
+		if tools[action.ConfigurationID] {
+			continue
+		}
+		for _, id := range action.InputDepSetIDs {
+			visit(id)
+		}
+	}
+
+	res := make([]string, 0, len(inputs))
+	for path := range inputs {
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func processStarlarkCQuery(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
 func (b *bazel) Build(args ...string) (*bytes.Buffer, error) {
 	stdoutBuffer, stderrBuffer := b.newCommand("build", append(b.args, args...)...)
 	err := b.cmd.Run()
@@ -383,6 +572,16 @@ func (b *bazel) Build(args ...string) (*bytes.Buffer, error) {
 	return stdoutBuffer, err
 }
 
+// Fetches the external repositories, `bazel fetch --force` fetches them again
+// even if they are up to date.
+func (b *bazel) Fetch(args ...string) (*bytes.Buffer, error) {
+	stdoutBuffer, stderrBuffer := b.newCommand("fetch", args...)
+	err := b.cmd.Run()
+
+	_, _ = stdoutBuffer.Write(stderrBuffer.Bytes())
+	return stdoutBuffer, err
+}
+
 // Builds a target using `bazel run --norun` to get the target's runfiles setup correctly even under `--remote_download_outputs=minimal`
 func (b *bazel) Norun(args ...string) (*bytes.Buffer, error) {
 	stdoutBuffer, stderrBuffer := b.newCommand("run", append(append(b.args, "--norun"), args...)...)
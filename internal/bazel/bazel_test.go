@@ -50,6 +50,81 @@ KEY3: value`)
 	}
 }
 
+func TestProcessStarlarkCQuery(t *testing.T) {
+	got := processStarlarkCQuery("bazel-out/k8-fastbuild/bin/foo/foo\n\nNone\n")
+	expected := []string{"bazel-out/k8-fastbuild/bin/foo/foo", "None"}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Lines were unequal. Got:\n%q\nExpected:\n%q", got, expected)
+	}
+}
+
+func TestProcessAQueryInputs(t *testing.T) {
+	for name, out := range map[string]string{
+		"path fragments": `{
+			"artifacts": [
+				{"id": 1, "pathFragmentId": 2},
+				{"id": 2, "pathFragmentId": 4},
+				{"id": 3, "pathFragmentId": 5},
+				{"id": 4, "pathFragmentId": 6}
+			],
+			"actions": [
+				{"configurationId": 1, "inputDepSetIds": [1]},
+				{"configurationId": 2, "inputDepSetIds": [3]}
+			],
+			"depSetOfFiles": [
+				{"id": 1, "directArtifactIds": [1], "transitiveDepSetIds": [2]},
+				{"id": 2, "directArtifactIds": [2, 1]},
+				{"id": 3, "directArtifactIds": [4]}
+			],
+			"configuration": [
+				{"id": 1, "mnemonic": "k8-fastbuild"},
+				{"id": 2, "mnemonic": "k8-opt-exec", "isTool": true}
+			],
+			"pathFragments": [
+				{"id": 1, "label": "foo"},
+				{"id": 2, "label": "foo.go", "parentId": 1},
+				{"id": 3, "label": "external"},
+				{"id": 4, "label": "lib.go", "parentId": 7},
+				{"id": 5, "label": "unused.go"},
+				{"id": 6, "label": "tool.go", "parentId": 1},
+				{"id": 7, "label": "repo", "parentId": 3}
+			]
+		}`,
+		"exec paths": `{
+			"artifacts": [
+				{"id": "1", "execPath": "foo/foo.go"},
+				{"id": "2", "execPath": "external/repo/lib.go"},
+				{"id": "4", "execPath": "foo/tool.go"}
+			],
+			"actions": [
+				{"configurationId": "1", "inputDepSetIds": ["1"]},
+				{"configurationId": "2", "inputDepSetIds": ["3"]}
+			],
+			"depSetOfFiles": [
+				{"id": "1", "directArtifactIds": ["1"], "transitiveDepSetIds": ["2"]},
+				{"id": "2", "directArtifactIds": ["2"]},
+				{"id": "3", "directArtifactIds": ["4"]}
+			],
+			"configuration": [
+				{"id": "1"},
+				{"id": "2", "isTool": true}
+			]
+		}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := processAQueryInputs([]byte(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"external/repo/lib.go", "foo/foo.go"}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Inputs were unequal. Got:\n%q\nExpected:\n%q", got, expected)
+			}
+		})
+	}
+}
+
 func TestWriteToStderrAndStdout(t *testing.T) {
 	b := &bazel{}
 	stdoutBuffer := new(bytes.Buffer)
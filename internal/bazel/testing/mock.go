@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/analysis"
@@ -30,12 +31,16 @@ import (
 )
 
 type MockBazel struct {
-	actions        [][]string
-	queryResponse  map[string]*blaze_query.QueryResult
-	cqueryResponse map[string]*analysis.CqueryResult
-	args           []string
-	startupArgs    []string
-	info           map[string]string
+	actions                [][]string
+	queryResponse          map[string]*blaze_query.QueryResult
+	cqueryResponse         map[string]*analysis.CqueryResult
+	starlarkCQueryResponse map[string][]string
+	aqueryInputsResponse   map[string][]string
+	args                   []string
+	startupArgs            []string
+	info                   map[string]string
+	repoMapping            map[string]string
+	modResponse            map[string]string
 
 	buildError error
 	waitError  error
@@ -58,6 +63,9 @@ func (b *MockBazel) SetStartupArgs(args []string) {
 func (b *MockBazel) SetInfo(info map[string]string) {
 	b.info = info
 }
+func (b *MockBazel) SetRepoMapping(mapping map[string]string) {
+	b.repoMapping = mapping
+}
 func (b *MockBazel) WriteToStderr(v bool) {
 	b.actions = append(b.actions, []string{"WriteToStderr", fmt.Sprint(v)})
 }
@@ -70,7 +78,24 @@ func (b *MockBazel) Info() (map[string]string, *bytes.Buffer, error) {
 }
 func (b *MockBazel) DumpRepoMapping(canonicalRepoName string) (map[string]string, *bytes.Buffer, error) {
 	b.actions = append(b.actions, []string{"DumpRepoMapping", canonicalRepoName})
-	return map[string]string{}, nil, nil
+	if b.repoMapping == nil {
+		return map[string]string{}, nil, nil
+	}
+	return b.repoMapping, nil, nil
+}
+func (b *MockBazel) AddModResponse(args string, out string) {
+	if b.modResponse == nil {
+		b.modResponse = map[string]string{}
+	}
+	b.modResponse[args] = out
+}
+func (b *MockBazel) Mod(args ...string) (*bytes.Buffer, error) {
+	b.actions = append(b.actions, append([]string{"Mod"}, args...))
+	out, ok := b.modResponse[strings.Join(args, " ")]
+	if !ok {
+		return nil, fmt.Errorf("no bazel mod output for %q", strings.Join(args, " "))
+	}
+	return bytes.NewBufferString(out), nil
 }
 func (b *MockBazel) AddQueryResponse(query string, res *blaze_query.QueryResult) {
 	if b.queryResponse == nil {
@@ -117,6 +142,44 @@ func (b *MockBazel) CQuery(args ...string) (*analysis.CqueryResult, error) {
 
 	return res, nil
 }
+func (b *MockBazel) AddStarlarkCQueryResponse(query string, res []string) {
+	if b.starlarkCQueryResponse == nil {
+		b.starlarkCQueryResponse = map[string][]string{}
+	}
+	b.starlarkCQueryResponse[query] = res
+}
+func (b *MockBazel) StarlarkCQuery(expr string, args ...string) ([]string, error) {
+	b.actions = append(b.actions, append([]string{"StarlarkCQuery", expr}, args...))
+	query := args[len(args)-1]
+	res, ok := b.starlarkCQueryResponse[query]
+	if !ok {
+		return nil, fmt.Errorf("no starlark cquery result for %q", query)
+	}
+
+	return res, nil
+}
+func (b *MockBazel) AddAQueryInputsResponse(query string, res []string) {
+	if b.aqueryInputsResponse == nil {
+		b.aqueryInputsResponse = map[string][]string{}
+	}
+	b.aqueryInputsResponse[query] = res
+}
+func (b *MockBazel) AQueryInputs(args ...string) ([]string, error) {
+	b.actions = append(b.actions, append([]string{"AQueryInputs"}, args...))
+	query := args[0]
+	res, ok := b.aqueryInputsResponse[query]
+
+	if !ok {
+		var candidates []string
+		for candidate := range b.aqueryInputsResponse {
+			candidates = append(candidates, candidate)
+		}
+		panic(fmt.Sprintf("Unable to find aquery result for %q. Only have %v.", query, candidates))
+	}
+
+	return res, nil
+}
+
 func (b *MockBazel) Build(args ...string) (*bytes.Buffer, error) {
 	b.actions = append(b.actions, append([]string{"Build"}, args...))
 	return nil, b.buildError
@@ -124,6 +187,10 @@ func (b *MockBazel) Build(args ...string) (*bytes.Buffer, error) {
 func (b *MockBazel) BuildError(e error) {
 	b.buildError = e
 }
+func (b *MockBazel) Fetch(args ...string) (*bytes.Buffer, error) {
+	b.actions = append(b.actions, append([]string{"Fetch"}, args...))
+	return nil, nil
+}
 func (b *MockBazel) Test(args ...string) (*bytes.Buffer, error) {
 	b.actions = append(b.actions, append([]string{"Test"}, args...))
 	return nil, nil
@@ -18,8 +18,20 @@ sh_binary(
   srcs = ["simple.sh"],
 )
 
+sh_binary(
+  name = "config",
+  srcs = ["config.sh"],
+  data = ["config.txt"],
+)
+
 -- simple.sh --
 printf "Started 1!"
+-- config.sh --
+cat config.txt
+-- config.dev.txt --
+Config dev!
+-- config.prod.txt --
+Config prod!
 `
 
 func TestMain(m *testing.M) {
@@ -79,3 +91,20 @@ func TestSymlinkRun(t *testing.T) {
 	e2e.MustWriteFile(t, "simple.sh", `printf "Started 2!"`)
 	ibazel.ExpectOutput("Started 2!")
 }
+
+// Repointing a symlink in the workspace changes the file bazel reads through
+// it, without touching either file.
+func TestSymlinkRetarget(t *testing.T) {
+	e2e.Must(t, os.Symlink("config.dev.txt", "config.txt"))
+
+	ibazel := e2e.SetUp(t)
+	ibazel.Run([]string{}, "//:config")
+	defer ibazel.Kill()
+
+	ibazel.ExpectOutput("Config dev!", 35*time.Second)
+
+	// Swap the link atomically, like `ln -sfn` does.
+	e2e.Must(t, os.Symlink("config.prod.txt", "config.txt.tmp"))
+	e2e.Must(t, os.Rename("config.txt.tmp", "config.txt"))
+	ibazel.ExpectOutput("Config prod!")
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bazel_config finds the files that configure how Bazel builds a
+// workspace: the chain of .bazelrc files and the .bazelversion file read by
+// Bazelisk.
+package bazel_config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// VersionFile is the file in the workspace root that pins the Bazel version.
+const VersionFile = ".bazelversion"
+
+// Files returns the rc files Bazel reads for the workspace given startupArgs,
+// following their import and try-import lines, and the version file. The
+// workspace rc file, imported files and the version file are returned even if
+// they don't exist yet, since creating them changes the configuration too. The
+// system and home rc files are only returned if they exist.
+func Files(workspace string, startupArgs []string) []string {
+	var files []string
+	seen := map[string]struct{}{}
+	var follow func(path string, optional bool)
+	follow = func(path string, optional bool) {
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		imports, err := imports(workspace, path)
+		if err != nil && (optional || !os.IsNotExist(err)) {
+			return
+		}
+		files = append(files, path)
+		for _, imported := range imports {
+			follow(imported, false)
+		}
+	}
+
+	for _, rc := range rcFiles(workspace, startupArgs) {
+		follow(rc.path, rc.optional)
+	}
+	files = append(files, filepath.Join(workspace, VersionFile))
+	return files
+}
+
+// Version returns the Bazel version pinned in the workspace, or "" if none is.
+func Version(workspace string) string {
+	content, err := os.ReadFile(filepath.Join(workspace, VersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// rcFile is an rc file Bazel starts from. Optional ones are outside the
+// workspace and only watched if they exist.
+type rcFile struct {
+	path     string
+	optional bool
+}
+
+// rcFiles returns the rc files Bazel starts from, in the order it reads them.
+func rcFiles(workspace string, startupArgs []string) []rcFile {
+	system, workspaceRC, home := true, true, true
+	var explicit []string
+	for _, arg := range startupArgs {
+		switch {
+		case arg == "--nosystem_rc" || arg == "--system_rc=false":
+			system = false
+		case arg == "--noworkspace_rc" || arg == "--workspace_rc=false":
+			workspaceRC = false
+		case arg == "--nohome_rc" || arg == "--home_rc=false":
+			home = false
+		case strings.HasPrefix(arg, "--bazelrc="):
+			explicit = append(explicit, strings.TrimPrefix(arg, "--bazelrc="))
+		}
+	}
+
+	var files []rcFile
+	if system {
+		if runtime.GOOS == "windows" {
+			files = append(files, rcFile{filepath.Join(os.Getenv("ProgramData"), "bazel.bazelrc"), true})
+		} else {
+			files = append(files, rcFile{"/etc/bazel.bazelrc", true})
+		}
+	}
+	if workspaceRC {
+		files = append(files, rcFile{filepath.Join(workspace, ".bazelrc"), false})
+	}
+	if dir, err := os.UserHomeDir(); err == nil && home {
+		files = append(files, rcFile{filepath.Join(dir, ".bazelrc"), true})
+	}
+	for _, rc := range explicit {
+		// --bazelrc=/dev/null stops Bazel from reading further --bazelrc files.
+		if rc == "/dev/null" {
+			break
+		}
+		files = append(files, rcFile{resolve(workspace, rc), false})
+	}
+	return files
+}
+
+// imports returns the files imported by the rc file at path.
+func imports(workspace, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	var line string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines ending with a backslash continue on the next one.
+		text := scanner.Text()
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\")
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) == 2 && (fields[0] == "import" || fields[0] == "try-import") {
+			files = append(files, resolve(workspace, strings.Trim(fields[1], `"'`)))
+		}
+	}
+	return files, scanner.Err()
+}
+
+// resolve expands %workspace% in path and makes it absolute.
+func resolve(workspace, path string) string {
+	path = strings.ReplaceAll(path, "%workspace%", workspace)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspace, path)
+	}
+	return filepath.Clean(path)
+}
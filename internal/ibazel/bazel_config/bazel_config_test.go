@@ -0,0 +1,104 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel_config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	ws := t.TempDir()
+	extra := filepath.Join(t.TempDir(), "ci.bazelrc")
+	write(t, filepath.Join(ws, ".bazelrc"), `
+build --announce_rc
+import %workspace%/tools/common.bazelrc
+try-import %workspace%/user.bazelrc
+try-import %workspace%/missing.bazelrc
+`)
+	write(t, filepath.Join(ws, "tools", "common.bazelrc"), `
+# import %workspace%/commented.bazelrc
+build --config=remote \
+  --jobs=8
+import %workspace%/.bazelrc
+`)
+	write(t, filepath.Join(ws, "user.bazelrc"), ``)
+	write(t, extra, `try-import "%workspace%/tools/ci.bazelrc"`)
+	write(t, filepath.Join(ws, "tools", "ci.bazelrc"), ``)
+	write(t, filepath.Join(ws, ".bazelversion"), "7.1.0\n")
+
+	got := Files(ws, []string{"--nosystem_rc", "--nohome_rc", "--bazelrc=" + extra})
+	want := []string{
+		filepath.Join(ws, ".bazelrc"),
+		filepath.Join(ws, "tools", "common.bazelrc"),
+		filepath.Join(ws, "user.bazelrc"),
+		filepath.Join(ws, "missing.bazelrc"),
+		extra,
+		filepath.Join(ws, "tools", "ci.bazelrc"),
+		filepath.Join(ws, ".bazelversion"),
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestFiles_devNull(t *testing.T) {
+	ws := t.TempDir()
+	write(t, filepath.Join(ws, "a.bazelrc"), ``)
+	write(t, filepath.Join(ws, "b.bazelrc"), ``)
+
+	got := Files(ws, []string{"--nosystem_rc", "--nohome_rc", "--noworkspace_rc", "--bazelrc=a.bazelrc", "--bazelrc=/dev/null", "--bazelrc=b.bazelrc"})
+	if want := []string{filepath.Join(ws, "a.bazelrc"), filepath.Join(ws, ".bazelversion")}; !reflect.DeepEqual(want, got) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestFiles_missing(t *testing.T) {
+	ws := t.TempDir()
+
+	// Files that don't exist yet are returned, so that creating them is
+	// noticed.
+	got := Files(ws, []string{"--nosystem_rc", "--nohome_rc"})
+	want := []string{
+		filepath.Join(ws, ".bazelrc"),
+		filepath.Join(ws, ".bazelversion"),
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	ws := t.TempDir()
+	if got := Version(ws); got != "" {
+		t.Errorf("Version() = %q, want none", got)
+	}
+	write(t, filepath.Join(ws, ".bazelversion"), "7.1.0\n")
+	if got := Version(ws); got != "7.1.0" {
+		t.Errorf("Version() = %q, want 7.1.0", got)
+	}
+}
@@ -19,10 +19,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -32,12 +35,56 @@ import (
 )
 
 var (
-	execCommand  = process_group.Command
+	execCommand  = newProcessGroup
 	bazelNew     = bazel.New
 	waitDuration = flag.Duration(
 		"graceful_termination_wait_duration",
 		10*time.Second,
 		"Specify the duration to wait for a graceful termination before sending SIGKILL to the subprocess")
+	restartPolicyFlag = flag.String(
+		"run_restart_policy",
+		restartNever,
+		"Restart a run target that exits without iBazel asking it to: never, on-failure or always")
+	restartMax = flag.Int(
+		"run_restart_max",
+		5,
+		"Maximum number of consecutive restarts of a run target that keeps exiting. 0 means no limit")
+	restartBackoff = flag.Duration(
+		"run_restart_backoff",
+		time.Second,
+		"Delay before the first restart of a run target that exited. Doubles with every consecutive restart")
+	overlapRestart = flag.Bool(
+		"run_overlap",
+		false,
+		"Start the new process of a run target and wait for it to be ready before terminating the old one")
+	readyPort = flag.Int(
+		"run_ready_port",
+		0,
+		"Port that accepts connections once a new process of a run target is ready. Used by --run_overlap")
+	readyTimeout = flag.Duration(
+		"run_ready_timeout",
+		30*time.Second,
+		"Maximum duration to wait for a new process of a run target to become ready. Used by --run_overlap")
+	usePTY = flag.Bool(
+		"run_pty",
+		false,
+		"Run the process of a run target on a pseudo-terminal (Linux only)")
+	useCgroup = flag.Bool(
+		"run_cgroup",
+		false,
+		"Put the processes of a run target in a cgroup of their own, so that all of them are terminated (Linux only, needs a writable cgroup v2)")
+	memoryLimit = flag.String(
+		"run_memory_limit",
+		"",
+		"Maximum memory for the processes of a run target, such as 512M. Used by --run_cgroup")
+	cpuLimit = flag.Float64(
+		"run_cpu_limit",
+		0,
+		"Maximum number of CPUs worth of time for the processes of a run target, such as 1.5. Used by --run_cgroup")
+	listenAddr = flag.String(
+		"run_listen",
+		"",
+		"Address for iBazel to listen on and pass to every process of a run target as a systemd-style socket (LISTEN_FDS)")
 )
 
 // Command is an object that wraps the logic of running a task in Bazel and
@@ -48,11 +95,73 @@ type Command interface {
 	Kill()
 	NotifyOfChanges() *bytes.Buffer
 	IsSubprocessRunning() bool
+	SetExitHandler(handler ExitHandler)
+	SetStartHandler(handler StartHandler)
+	Signal(signum syscall.Signal) error
+	// SetEnv sets extra environment variables, as KEY=value, for the processes
+	// started from now on.
+	SetEnv(env []string)
+	// SetIteration sets the iteration of iBazel that the processes started
+	// from now on belong to, which names their log files.
+	SetIteration(iteration int)
+	// Restart replaces the process with a new one that runs the last build,
+	// with the environment given to SetEnv.
+	Restart() error
+}
+
+// StartHandler is called with the pid of every process started for a target,
+// including restarts. The pid is also the id of the process group.
+type StartHandler func(pid int)
+
+// Options override the global flags for a single target. Zero values keep the
+// values of the flags.
+type Options struct {
+	// RestartSignal is sent to stop the process instead of SIGTERM.
+	RestartSignal syscall.Signal
+	// GracePeriod overrides --graceful_termination_wait_duration.
+	GracePeriod time.Duration
+	// NoRestart overrides --run_restart_policy with "never".
+	NoRestart bool
+	// ReadyPort overrides --run_ready_port.
+	ReadyPort int
+}
+
+func (o Options) restartSignal() syscall.Signal {
+	if o.RestartSignal != 0 {
+		return o.RestartSignal
+	}
+	return syscall.SIGTERM
+}
+
+func (o Options) gracePeriod() time.Duration {
+	if o.GracePeriod != 0 {
+		return o.GracePeriod
+	}
+	return *waitDuration
+}
+
+func (o Options) readyPort() int {
+	if o.ReadyPort != 0 {
+		return o.ReadyPort
+	}
+	return *readyPort
 }
 
 // start will be called by most implementations since this logic is extremely
-// common.
-func start(b bazel.Bazel, target string, args []string) (*bytes.Buffer, process_group.ProcessGroup) {
+// common. An error is only returned if the process can't be started at all.
+func start(b bazel.Bazel, target string, args []string) (*bytes.Buffer, process_group.ProcessGroup, error) {
+	if *directRun {
+		outputBuffer, err := b.Build(target)
+		if err != nil {
+			return outputBuffer, nil, err
+		}
+		cmd, err := directCommand(b, target, args)
+		if err == nil {
+			return outputBuffer, cmd, nil
+		}
+		log.Errorf("Unable to run %s directly, falling back to bazel run: %v", target, err)
+	}
+
 	var filePattern strings.Builder
 	filePattern.WriteString("bazel_script_path*")
 	if runtime.GOOS == "windows" {
@@ -75,11 +184,98 @@ func start(b bazel.Bazel, target string, args []string) (*bytes.Buffer, process_
 
 	// Now that we have built the target, construct a executable form of it for
 	// execution in a go routine.
-	cmd := execCommand(runScriptPath, args...)
+	cmd := runCommand(runScriptPath, args...)
 	cmd.RootProcess().Stdout = os.Stdout
 	cmd.RootProcess().Stderr = os.Stderr
+	cmd.RootProcess().Env = processEnv(cmd)
+
+	return outputBuffer, cmd, nil
+}
+
+var cgroupWarning sync.Once
+
+// newProcessGroup creates a process group in a cgroup of its own if asked to
+// and possible, and a regular one otherwise.
+func newProcessGroup(name string, args ...string) process_group.ProcessGroup {
+	if *useCgroup {
+		limits, err := cgroupLimits()
+		if err == nil {
+			var pg process_group.ProcessGroup
+			if pg, err = process_group.CgroupCommand(limits, name, args...); err == nil {
+				return pg
+			}
+		}
+		cgroupWarning.Do(func() {
+			log.Errorf("Not using a cgroup for the run target: %v", err)
+		})
+	}
+	return process_group.Command(name, args...)
+}
 
-	return outputBuffer, cmd
+func cgroupLimits() (process_group.CgroupLimits, error) {
+	limits := process_group.CgroupLimits{CPUs: *cpuLimit}
+	if *memoryLimit != "" {
+		var err error
+		if limits.MemoryBytes, err = process_group.ParseBytes(*memoryLimit); err != nil {
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+// runCommand creates the process group that runs the script of a target.
+func runCommand(name string, args ...string) process_group.ProcessGroup {
+	if *listenAddr != "" {
+		pg, err := listenCommand(*listenAddr, name, args...)
+		if err == nil {
+			return pg
+		}
+		log.Errorf("Unable to pass a socket listening on %s to the process: %v", *listenAddr, err)
+	}
+	return execCommand(name, args...)
+}
+
+// processEnv is the environment every process of a run target starts with.
+// Relaunched processes keep the environment they were created with.
+func processEnv(pg process_group.ProcessGroup) []string {
+	env := os.Environ()
+	if len(pg.RootProcess().ExtraFiles) > 0 {
+		env = append(env, listenEnv()...)
+	}
+	return env
+}
+
+// waitReady blocks until something accepts connections on port, pg exits or
+// the timeout elapses.
+func waitReady(pg *monitoredProcessGroup, port int, timeout time.Duration) {
+	if port == 0 {
+		return
+	}
+
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+	deadline := time.After(timeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return
+		}
+		select {
+		case <-pg.done:
+			return
+		case <-deadline:
+			log.Errorf("The new process didn't accept connections on port %d within %s", port, timeout)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// processRunning reports whether pg has a running process.
+func processRunning(pg process_group.ProcessGroup) bool {
+	if m, ok := pg.(*monitoredProcessGroup); ok {
+		return m.running()
+	}
+	return subprocessRunning(pg.RootProcess())
 }
 
 func subprocessRunning(cmd *exec.Cmd) bool {
@@ -98,16 +294,30 @@ func subprocessRunning(cmd *exec.Cmd) bool {
 	return true
 }
 
-func terminate(pg process_group.ProcessGroup) {
-	pg.Signal(syscall.SIGTERM)
+// terminateOnce terminates pg. Concurrent calls for the same process wait for
+// the first one instead of signalling it again.
+func terminateOnce(pg process_group.ProcessGroup, o Options) {
+	if m, ok := pg.(*monitoredProcessGroup); ok {
+		m.stopping.Do(func() {
+			terminate(m, o)
+		})
+		return
+	}
+	terminate(pg, o)
+}
+
+func terminate(pg process_group.ProcessGroup, o Options) {
+	expectExit(pg)
+	pg.Signal(o.restartSignal())
+	wait := o.gracePeriod()
 	done := make(chan bool, 1)
 	go func() {
 		select {
-		case <-time.After(*waitDuration):
-			log.Logf("The subprocess wasn't terminated within %s. Forcing to close.", *waitDuration)
+		case <-time.After(wait):
+			log.Logf("The subprocess wasn't terminated within %s. Forcing to close.", wait)
 			kill(pg)
 		case <-done:
-			// The subprocess was terminated with SIGTERM
+			// The subprocess was terminated with the restart signal
 		}
 	}()
 	pg.Wait()
@@ -116,8 +326,9 @@ func terminate(pg process_group.ProcessGroup) {
 }
 
 func kill(pg process_group.ProcessGroup) {
-	if subprocessRunning(pg.RootProcess()) {
+	if processRunning(pg) {
 		log.Logf("Sending SIGKILL to the subprocess")
+		expectExit(pg)
 		pg.Signal(syscall.SIGKILL)
 	}
 }
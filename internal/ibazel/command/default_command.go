@@ -16,8 +16,8 @@ package command
 
 import (
 	"bytes"
-	"os"
 	"sync"
+	"syscall"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
@@ -29,35 +29,49 @@ type defaultCommand struct {
 	bazelArgs   []string
 	args        []string
 	pg          process_group.ProcessGroup
-	termSync    sync.Once
+	opts        Options
+	env         []string
+	baseEnv     []string // environment of the last build, without env
+	restarts    *restartPolicy
+	logs        *runLogs
+	onExit      ExitHandler
+	onStart     StartHandler
+	mu          sync.Mutex // guards pg against crash restarts
 }
 
 // DefaultCommand is the normal mode of interacting with iBazel. If you start a
 // server in this mode and notify of changes the server will be killed and
 // restarted.
-func DefaultCommand(startupArgs []string, bazelArgs []string, target string, args []string) Command {
+func DefaultCommand(startupArgs []string, bazelArgs []string, target string, args []string, opts Options) Command {
 	return &defaultCommand{
 		target:      target,
 		startupArgs: startupArgs,
 		bazelArgs:   bazelArgs,
 		args:        args,
+		opts:        opts,
+		restarts:    newRestartPolicy(opts),
+		logs:        newRunLogs(target),
 	}
 }
 
 func (c *defaultCommand) Terminate() {
-	if !c.IsSubprocessRunning() {
+	// Don't hold c.mu while waiting for the process to exit, so that it can
+	// still be killed in the meantime.
+	pg, running := c.current()
+	if running {
+		terminateOnce(pg, c.opts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pg == pg {
 		c.pg = nil
-		return
 	}
-	c.termSync.Do(func() {
-		terminate(c.pg)
-	})
-	c.pg = nil
 }
 
 func (c *defaultCommand) Kill() {
-	if c.pg != nil {
-		kill(c.pg)
+	if pg, _ := c.current(); pg != nil {
+		kill(pg)
 	}
 }
 
@@ -69,27 +83,156 @@ func (c *defaultCommand) Start() (*bytes.Buffer, error) {
 	b.WriteToStderr(true)
 	b.WriteToStdout(true)
 
-	var outputBuffer *bytes.Buffer
-	outputBuffer, c.pg = start(b, c.target, c.args)
+	outputBuffer, pg, err := start(b, c.target, c.args)
+	if err != nil {
+		log.Errorf("Build failed: %v", err)
+		return outputBuffer, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseEnv = pg.RootProcess().Env
+	pg.RootProcess().Env = withEnv(c.baseEnv, c.env...)
+	c.restarts.reset()
+	return outputBuffer, c.launch(pg)
+}
+
+// overlap starts a new process next to the running one and only terminates
+// the old process once the new one is ready, so that the target stays
+// reachable during the restart.
+func (c *defaultCommand) overlap() *bytes.Buffer {
+	c.mu.Lock()
+	old := c.pg
+	c.mu.Unlock()
+
+	outputBuffer, err := c.Start()
+	if err != nil {
+		log.Errorf("The new process failed to start. Keeping the previous process running.")
+		c.mu.Lock()
+		c.pg = old
+		c.mu.Unlock()
+		return outputBuffer
+	}
+
+	c.mu.Lock()
+	next := c.pg.(*monitoredProcessGroup)
+	c.mu.Unlock()
 
-	c.pg.RootProcess().Env = os.Environ()
+	waitReady(next, c.opts.readyPort(), *readyTimeout)
+	if next.exited() {
+		log.Errorf("The new process exited before becoming ready. Keeping the previous process running.")
+		c.mu.Lock()
+		c.pg = old
+		c.mu.Unlock()
+		return outputBuffer
+	}
+
+	terminateOnce(old, c.opts)
+	return outputBuffer
+}
 
-	var err error
-	if err = c.pg.Start(); err != nil {
+// launch starts pg as the current process. c.mu must be held.
+func (c *defaultCommand) launch(pg process_group.ProcessGroup) error {
+	m := monitor(pg, c.processExited)
+	m.captureOutput(c.logs)
+	if *usePTY {
+		m.output = attachTerminal(pg)
+	}
+
+	c.pg = m
+	if err := c.pg.Start(); err != nil {
 		log.Errorf("Error starting process: %v", err)
-		return outputBuffer, err
+		return err
 	}
 	log.Log("Starting...")
-	c.termSync = sync.Once{}
-	return outputBuffer, nil
+	if c.onStart != nil {
+		c.onStart(c.pg.RootProcess().Process.Pid)
+	}
+	return nil
+}
+
+func (c *defaultCommand) processExited(pg *monitoredProcessGroup, status ExitStatus) {
+	handleExit(c.target, status, c.onExit, c.restarts, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A rebuild or a termination may have replaced the process already.
+		if c.pg != pg {
+			return
+		}
+		c.launch(relaunchCommand(pg))
+	})
 }
 
 func (c *defaultCommand) NotifyOfChanges() *bytes.Buffer {
+	if *overlapRestart && c.IsSubprocessRunning() {
+		return c.overlap()
+	}
 	c.Terminate()
 	c.Start()
 	return nil
 }
 
+func (c *defaultCommand) SetExitHandler(handler ExitHandler) {
+	c.onExit = handler
+}
+
+func (c *defaultCommand) SetStartHandler(handler StartHandler) {
+	c.onStart = handler
+}
+
+func (c *defaultCommand) SetEnv(env []string) {
+	c.env = env
+}
+
+func (c *defaultCommand) SetIteration(iteration int) {
+	c.logs.setIteration(iteration)
+}
+
+func (c *defaultCommand) Restart() error {
+	pg, running := c.current()
+	last, ok := pg.(*monitoredProcessGroup)
+	if !ok {
+		return errNotStarted
+	}
+	if running {
+		terminateOnce(last, c.opts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A rebuild or a termination may have replaced the process meanwhile.
+	if c.pg != pg {
+		return nil
+	}
+	next := relaunchCommand(last)
+	next.RootProcess().Env = withEnv(c.baseEnv, c.env...)
+	c.restarts.reset()
+	return c.launch(next)
+}
+
+func (c *defaultCommand) Signal(signum syscall.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running() {
+		return nil
+	}
+	return c.pg.Signal(signum)
+}
+
 func (c *defaultCommand) IsSubprocessRunning() bool {
-	return c.pg != nil && subprocessRunning(c.pg.RootProcess())
+	_, running := c.current()
+	return running
+}
+
+// current returns the current process and whether it is running. The process
+// group is safe to use without c.mu.
+func (c *defaultCommand) current() (process_group.ProcessGroup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pg, c.running()
+}
+
+// running reports whether the current process is running. c.mu must be held.
+func (c *defaultCommand) running() bool {
+	return c.pg != nil && processRunning(c.pg)
 }
@@ -18,7 +18,9 @@ import (
 	"os"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/bazel-watcher/internal/bazel"
 	mock_bazel "github.com/bazelbuild/bazel-watcher/internal/bazel/testing"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
@@ -65,6 +67,9 @@ func TestDefaultCommand(t *testing.T) {
 	// This is synonymous with killing the job so use it to kill the job and test everything.
 	c.NotifyOfChanges()
 	assertKilled(t, toKill.RootProcess())
+
+	// Let the restarted process finish before the test's logger goes away.
+	c.pg.Wait()
 }
 
 func TestDefaultCommand_Start(t *testing.T) {
@@ -82,7 +87,7 @@ func TestDefaultCommand_Start(t *testing.T) {
 
 	b := &mock_bazel.MockBazel{}
 
-	_, pg := start(b, "//path/to:target", []string{"moo"})
+	_, pg, _ := start(b, "//path/to:target", []string{"moo"})
 	pg.Start()
 
 	if pg.RootProcess().Stdout != os.Stdout {
@@ -96,3 +101,143 @@ func TestDefaultCommand_Start(t *testing.T) {
 		[]string{"Run", "--script_path=.*", "//path/to:target"},
 	})
 }
+
+func TestDefaultCommand_SetEnv(t *testing.T) {
+	log.SetLogger(t)
+
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		if runtime.GOOS == "windows" {
+			// TODO(jchw): Remove hardcoded path.
+			return oldExecCommand("C:\\windows\\system32\\where")
+		}
+		return oldExecCommand("ls") // Every system has ls.
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	b := &mock_bazel.MockBazel{}
+	bazelNew = func() bazel.Bazel { return b }
+	defer func() { bazelNew = oldBazelNew }()
+
+	c := DefaultCommand(nil, nil, "//path/to:target", nil, Options{})
+	c.SetEnv([]string{"IBAZEL_ITERATION=3"})
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	pg := c.(*defaultCommand).pg
+	pg.Wait()
+
+	env := pg.RootProcess().Env
+	if len(env) == 0 || env[len(env)-1] != "IBAZEL_ITERATION=3" {
+		t.Errorf("The environment of the process doesn't end with IBAZEL_ITERATION=3: %v", env)
+	}
+}
+
+func TestDefaultCommand_Restart(t *testing.T) {
+	log.SetLogger(t)
+
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		if runtime.GOOS == "windows" {
+			// TODO(jchw): Remove hardcoded path.
+			return oldExecCommand("C:\\windows\\system32\\where")
+		}
+		return oldExecCommand("ls") // Every system has ls.
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	b := &mock_bazel.MockBazel{}
+	bazelNew = func() bazel.Bazel { return b }
+	defer func() { bazelNew = oldBazelNew }()
+
+	c := DefaultCommand(nil, nil, "//path/to:target", nil, Options{})
+	if err := c.Restart(); err == nil {
+		t.Errorf("Restart() before Start() should fail")
+	}
+
+	c.SetEnv([]string{"MODE=old"})
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	first := c.(*defaultCommand).pg
+	first.Wait()
+
+	c.SetEnv([]string{"MODE=new"})
+	if err := c.Restart(); err != nil {
+		t.Fatalf("Restart(): %v", err)
+	}
+	second := c.(*defaultCommand).pg
+	second.Wait()
+	if second == first {
+		t.Fatalf("Restart() didn't start a new process")
+	}
+
+	for _, kv := range second.RootProcess().Env {
+		if kv == "MODE=old" {
+			t.Errorf("The restarted process still has the old environment")
+		}
+	}
+	if env := second.RootProcess().Env; env[len(env)-1] != "MODE=new" {
+		t.Errorf("The environment of the restarted process doesn't end with MODE=new: %v", env)
+	}
+	// Only the environment is new, the build is reused.
+	b.AssertActions(t, [][]string{
+		{"SetStartupArgs"},
+		{"SetArguments"},
+		{"WriteToStderr", "true"},
+		{"WriteToStdout", "true"},
+		{"Run", "--script_path=.*", "//path/to:target"},
+	})
+}
+
+func TestDefaultCommand_KillDuringTerminate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM can't be ignored on Windows")
+	}
+	log.SetLogger(t)
+
+	// A process that ignores SIGTERM keeps Terminate waiting for the whole
+	// grace period.
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		return oldExecCommand("sh", "-c", "trap '' TERM; sleep 30")
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	b := &mock_bazel.MockBazel{}
+	bazelNew = func() bazel.Bazel { return b }
+	defer func() { bazelNew = oldBazelNew }()
+
+	c := DefaultCommand(nil, nil, "//path/to:target", nil, Options{GracePeriod: time.Minute})
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	// Give the shell time to ignore SIGTERM.
+	time.Sleep(200 * time.Millisecond)
+
+	terminated := make(chan struct{})
+	go func() {
+		c.Terminate()
+		close(terminated)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if !c.IsSubprocessRunning() {
+		t.Fatalf("The process should ignore SIGTERM")
+	}
+
+	killed := make(chan struct{})
+	go func() {
+		c.Kill()
+		close(killed)
+	}()
+	for _, step := range []struct {
+		done chan struct{}
+		what string
+	}{{killed, "Kill()"}, {terminated, "Terminate()"}} {
+		select {
+		case <-step.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s didn't return while the process was being terminated", step.what)
+		}
+	}
+	if c.IsSubprocessRunning() {
+		t.Errorf("The process should have been killed")
+	}
+}
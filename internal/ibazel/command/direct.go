@@ -0,0 +1,139 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/internal/bazel"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+var directRun = flag.Bool(
+	"run_direct",
+	false,
+	"Build run targets and execute their binary directly instead of through bazel run, so restarts don't need Bazel")
+
+// executableExpr is evaluated by a Starlark cquery to find the executable of a
+// target, relative to the execution root.
+const executableExpr = `target.files_to_run.executable.path if target.files_to_run.executable else ""`
+
+// executable is the binary of a target and where it was built.
+type executable struct {
+	path      string
+	workspace string
+}
+
+// executables caches the executable of every target that was run directly,
+// since finding it needs Bazel to analyze the target.
+var executables = struct {
+	sync.Mutex
+	byTarget map[string]executable
+}{byTarget: map[string]executable{}}
+
+// findExecutable returns the executable of target, which must be built.
+func findExecutable(b bazel.Bazel, target string) (executable, error) {
+	executables.Lock()
+	defer executables.Unlock()
+
+	if exe, ok := executables.byTarget[target]; ok {
+		if _, err := os.Stat(exe.path); err == nil {
+			return exe, nil
+		}
+	}
+
+	paths, err := b.StarlarkCQuery(executableExpr, target)
+	if err != nil {
+		return executable{}, err
+	}
+	if len(paths) != 1 || paths[0] == "" {
+		return executable{}, fmt.Errorf("%s doesn't have exactly one executable", target)
+	}
+	info, _, err := b.Info()
+	if err != nil {
+		return executable{}, err
+	}
+	if info["execution_root"] == "" {
+		return executable{}, errors.New("bazel info didn't report an execution_root")
+	}
+
+	exe := executable{
+		path:      filepath.Join(info["execution_root"], filepath.FromSlash(paths[0])),
+		workspace: info["workspace"],
+	}
+	executables.byTarget[target] = exe
+	return exe, nil
+}
+
+// directCommand creates a process group that runs the executable of target
+// the way bazel run would: from its runfiles and with the environment that
+// tells it where they are. The args and env attributes of the target are not
+// applied.
+func directCommand(b bazel.Bazel, target string, args []string) (process_group.ProcessGroup, error) {
+	exe, err := findExecutable(b, target)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := runCommand(exe.path, args...)
+	root := cmd.RootProcess()
+	root.Stdout = os.Stdout
+	root.Stderr = os.Stderr
+	root.Env = append(processEnv(cmd), exe.runfilesEnv()...)
+	root.Dir = exe.workingDirectory()
+	return cmd, nil
+}
+
+func (exe executable) runfiles() string {
+	return exe.path + ".runfiles"
+}
+
+func (exe executable) runfilesEnv() []string {
+	env := []string{
+		"RUNFILES_DIR=" + exe.runfiles(),
+		"JAVA_RUNFILES=" + exe.runfiles(),
+		"BUILD_WORKSPACE_DIRECTORY=" + exe.workspace,
+	}
+	for _, manifest := range []string{exe.path + ".runfiles_manifest", filepath.Join(exe.runfiles(), "MANIFEST")} {
+		if _, err := os.Stat(manifest); err == nil {
+			env = append(env, "RUNFILES_MANIFEST_FILE="+manifest)
+			break
+		}
+	}
+	if wd, err := os.Getwd(); err == nil {
+		env = append(env, "BUILD_WORKING_DIRECTORY="+wd)
+	}
+	return env
+}
+
+// workingDirectory is the directory of the main repository in the runfiles,
+// which is named _main with Bzlmod and __main__ without a workspace name.
+func (exe executable) workingDirectory() string {
+	for _, name := range []string{"_main", "__main__"} {
+		dir := filepath.Join(exe.runfiles(), name)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	if info, err := os.Stat(exe.runfiles()); err == nil && info.IsDir() {
+		return exe.runfiles()
+	}
+	return filepath.Dir(exe.path)
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mock_bazel "github.com/bazelbuild/bazel-watcher/internal/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+)
+
+func TestDirectCommand(t *testing.T) {
+	log.SetLogger(t)
+
+	execRoot := t.TempDir()
+	exePath := filepath.Join(execRoot, "bazel-out", "bin", "app", "app")
+	mainRunfiles := filepath.Join(exePath+".runfiles", "_main")
+	if err := os.MkdirAll(mainRunfiles, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exePath, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &mock_bazel.MockBazel{}
+	b.SetInfo(map[string]string{
+		"execution_root": execRoot,
+		"workspace":      "/workspace",
+	})
+	b.AddStarlarkCQueryResponse("//app:direct", []string{"bazel-out/bin/app/app"})
+
+	cmd, err := directCommand(b, "//app:direct", []string{"moo"})
+	if err != nil {
+		t.Fatalf("directCommand(): %v", err)
+	}
+	root := cmd.RootProcess()
+	if root.Path != exePath {
+		t.Errorf("Path = %q, want %q", root.Path, exePath)
+	}
+	if root.Dir != mainRunfiles {
+		t.Errorf("Dir = %q, want %q", root.Dir, mainRunfiles)
+	}
+	for _, want := range []string{
+		"RUNFILES_DIR=" + exePath + ".runfiles",
+		"BUILD_WORKSPACE_DIRECTORY=/workspace",
+	} {
+		if !containsString(root.Env, want) {
+			t.Errorf("Env doesn't contain %q", want)
+		}
+	}
+
+	// The executable is only looked up once.
+	if _, err := directCommand(b, "//app:direct", nil); err != nil {
+		t.Fatalf("directCommand(): %v", err)
+	}
+	b.AssertActions(t, [][]string{
+		{"StarlarkCQuery", executableExpr, "//app:direct"},
+		{"Info"},
+	})
+}
+
+func TestDirectCommand_NotExecutable(t *testing.T) {
+	log.SetLogger(t)
+
+	b := &mock_bazel.MockBazel{}
+	b.AddStarlarkCQueryResponse("//app:library", []string{""})
+
+	if _, err := directCommand(b, "//app:library", nil); err == nil {
+		t.Errorf("directCommand() should fail for a target without an executable")
+	}
+}
+
+func containsString(l []string, e string) bool {
+	for _, s := range l {
+		if s == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+// listenPIDWrapper sets LISTEN_PID to the pid of the shell and then replaces
+// the shell with the real program, which keeps that pid. The pid of the run
+// target isn't known before it is started, so this is the only way to satisfy
+// libraries that check LISTEN_PID.
+const listenPIDWrapper = `LISTEN_PID=$$; export LISTEN_PID; exec "$0" "$@"`
+
+var listenerFile *os.File
+
+// inheritedListener returns a file for a socket listening on addr. The socket
+// is opened the first time this is called and stays open for the lifetime of
+// iBazel, so the port never goes away between restarts.
+func inheritedListener(addr string) (*os.File, error) {
+	if listenerFile != nil {
+		return listenerFile, nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, fmt.Errorf("%s is not a TCP address", addr)
+	}
+	f, err := tcpListener.File()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	listenerFile = f
+	return listenerFile, nil
+}
+
+// listenCommand creates a process group for name that receives the socket
+// listening on addr as file descriptor 3, following the systemd socket
+// activation protocol.
+func listenCommand(addr string, name string, args ...string) (process_group.ProcessGroup, error) {
+	f, err := inheritedListener(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pg := execCommand("/bin/sh", append([]string{"-c", listenPIDWrapper, name}, args...)...)
+	pg.RootProcess().ExtraFiles = []*os.File{f}
+	return pg, nil
+}
+
+// listenEnv returns the environment that tells the run target about the socket
+// passed in by listenCommand.
+func listenEnv() []string {
+	return []string{"LISTEN_FDS=1", "LISTEN_FDNAMES=ibazel"}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+)
+
+func TestListenCommand(t *testing.T) {
+	log.SetLogger(t)
+
+	pg, err := listenCommand("localhost:0", "sh", "-c", `echo "$LISTEN_PID $LISTEN_FDS $1"`, "sh", "arg")
+	if err != nil {
+		t.Fatalf("listenCommand(): %v", err)
+	}
+	defer func() {
+		listenerFile.Close()
+		listenerFile = nil
+	}()
+
+	var out bytes.Buffer
+	pg.RootProcess().Stdout = &out
+	pg.RootProcess().Env = processEnv(pg)
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	pid := pg.RootProcess().Process.Pid
+	pg.Wait()
+
+	fields := strings.Fields(out.String())
+	if len(fields) != 3 {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+	if want := []string{strconv.Itoa(pid), "1", "arg"}; strings.Join(fields, " ") != strings.Join(want, " ") {
+		t.Errorf("Got %v, want %v", fields, want)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	log.SetLogger(t)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	pg := monitor(execCommand("sleep", "10"), nil)
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	defer terminate(pg, Options{})
+
+	start := time.Now()
+	waitReady(pg, port, 5*time.Second)
+	if time.Since(start) > time.Second {
+		t.Errorf("waitReady() took %s for a port that was already listening", time.Since(start))
+	}
+
+	exiting := monitor(execCommand("true"), nil)
+	if err := exiting.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	l.Close()
+	start = time.Now()
+	waitReady(exiting, port, 5*time.Second)
+	if time.Since(start) > time.Second {
+		t.Errorf("waitReady() kept waiting for a process that exited")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"errors"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+func listenCommand(addr string, name string, args ...string) (process_group.ProcessGroup, error) {
+	return nil, errors.New("passing a listening socket to the run target is not supported on Windows")
+}
+
+func listenEnv() []string {
+	return nil
+}
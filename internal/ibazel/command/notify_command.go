@@ -17,8 +17,8 @@ package command
 import (
 	"bytes"
 	"io"
-	"os"
 	"sync"
+	"syscall"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
@@ -31,34 +31,48 @@ type notifyCommand struct {
 	args        []string
 	pg          process_group.ProcessGroup
 	stdin       io.WriteCloser
-	termSync    sync.Once
+	opts        Options
+	env         []string
+	baseEnv     []string // environment of the last build, without env
+	restarts    *restartPolicy
+	logs        *runLogs
+	onExit      ExitHandler
+	onStart     StartHandler
+	mu          sync.Mutex // guards pg and stdin against crash restarts
 }
 
 // NotifyCommand is an alternate mode for starting a command. In this mode the
 // command will be notified on stdin that the source files have changed.
-func NotifyCommand(startupArgs []string, bazelArgs []string, target string, args []string) Command {
+func NotifyCommand(startupArgs []string, bazelArgs []string, target string, args []string, opts Options) Command {
 	return &notifyCommand{
 		startupArgs: startupArgs,
 		target:      target,
 		bazelArgs:   bazelArgs,
 		args:        args,
+		opts:        opts,
+		restarts:    newRestartPolicy(opts),
+		logs:        newRunLogs(target),
 	}
 }
 
 func (c *notifyCommand) Terminate() {
-	if !c.IsSubprocessRunning() {
+	// Don't hold c.mu while waiting for the process to exit, so that it can
+	// still be killed in the meantime.
+	pg, running := c.current()
+	if running {
+		terminateOnce(pg, c.opts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pg == pg {
 		c.pg = nil
-		return
 	}
-	c.termSync.Do(func() {
-		terminate(c.pg)
-	})
-	c.pg = nil
 }
 
 func (c *notifyCommand) Kill() {
-	if c.pg != nil {
-		kill(c.pg)
+	if pg, _ := c.current(); pg != nil {
+		kill(pg)
 	}
 }
 
@@ -70,25 +84,58 @@ func (c *notifyCommand) Start() (*bytes.Buffer, error) {
 	b.WriteToStderr(true)
 	b.WriteToStdout(true)
 
-	var outputBuffer *bytes.Buffer
-	outputBuffer, c.pg = start(b, c.target, c.args)
+	outputBuffer, pg, err := start(b, c.target, c.args)
+	if err != nil {
+		log.Errorf("Build failed: %v", err)
+		return outputBuffer, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseEnv = pg.RootProcess().Env
+	pg.RootProcess().Env = c.processEnv()
+	c.restarts.reset()
+	return outputBuffer, c.launch(pg)
+}
+
+// launch starts pg as the current process. c.mu must be held.
+func (c *notifyCommand) launch(pg process_group.ProcessGroup) error {
+	m := monitor(pg, c.processExited)
+	m.captureOutput(c.logs)
+	c.pg = m
 	// Keep the writer around.
 	var err error
 	c.stdin, err = c.pg.RootProcess().StdinPipe()
 	if err != nil {
 		log.Errorf("Error getting stdin pipe: %v", err)
-		return outputBuffer, err
+		return err
 	}
 
-	c.pg.RootProcess().Env = append(os.Environ(), "IBAZEL_NOTIFY_CHANGES=y")
+	if *usePTY {
+		m.output = attachTerminal(pg)
+	}
 
 	if err = c.pg.Start(); err != nil {
 		log.Errorf("Error starting process: %v", err)
-		return outputBuffer, err
+		return err
 	}
 	log.Log("Starting...")
-	c.termSync = sync.Once{}
-	return outputBuffer, nil
+	if c.onStart != nil {
+		c.onStart(c.pg.RootProcess().Process.Pid)
+	}
+	return nil
+}
+
+func (c *notifyCommand) processExited(pg *monitoredProcessGroup, status ExitStatus) {
+	handleExit(c.target, status, c.onExit, c.restarts, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A rebuild or a termination may have replaced the process already.
+		if c.pg != pg {
+			return
+		}
+		c.launch(relaunchCommand(pg))
+	})
 }
 
 func (c *notifyCommand) NotifyOfChanges() *bytes.Buffer {
@@ -99,7 +146,11 @@ func (c *notifyCommand) NotifyOfChanges() *bytes.Buffer {
 	b.WriteToStderr(true)
 	b.WriteToStdout(true)
 
-	_, err := c.stdin.Write([]byte("IBAZEL_BUILD_STARTED\n"))
+	c.mu.Lock()
+	stdin := c.stdin
+	c.mu.Unlock()
+
+	_, err := stdin.Write([]byte("IBAZEL_BUILD_STARTED\n"))
 	if err != nil {
 		log.Errorf("Error writing build to stdin: %s", err)
 	}
@@ -107,13 +158,13 @@ func (c *notifyCommand) NotifyOfChanges() *bytes.Buffer {
 	outputBuffer, res := b.Norun(c.target)
 	if res != nil {
 		log.Errorf("IBAZEL BUILD FAILURE: %v", res)
-		_, err := c.stdin.Write([]byte("IBAZEL_BUILD_COMPLETED FAILURE\n"))
+		_, err := stdin.Write([]byte("IBAZEL_BUILD_COMPLETED FAILURE\n"))
 		if err != nil {
 			log.Errorf("Error writing failure to stdin: %s", err)
 		}
 	} else {
 		log.Log("IBAZEL BUILD SUCCESS")
-		_, err := c.stdin.Write([]byte("IBAZEL_BUILD_COMPLETED SUCCESS\n"))
+		_, err := stdin.Write([]byte("IBAZEL_BUILD_COMPLETED SUCCESS\n"))
 		if err != nil {
 			log.Errorf("Error writing success to stdin: %v", err)
 		}
@@ -126,6 +177,72 @@ func (c *notifyCommand) NotifyOfChanges() *bytes.Buffer {
 	return outputBuffer
 }
 
+func (c *notifyCommand) SetExitHandler(handler ExitHandler) {
+	c.onExit = handler
+}
+
+func (c *notifyCommand) SetStartHandler(handler StartHandler) {
+	c.onStart = handler
+}
+
+func (c *notifyCommand) SetEnv(env []string) {
+	c.env = env
+}
+
+func (c *notifyCommand) SetIteration(iteration int) {
+	c.logs.setIteration(iteration)
+}
+
+func (c *notifyCommand) Restart() error {
+	pg, running := c.current()
+	last, ok := pg.(*monitoredProcessGroup)
+	if !ok {
+		return errNotStarted
+	}
+	if running {
+		terminateOnce(last, c.opts)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A rebuild or a termination may have replaced the process meanwhile.
+	if c.pg != pg {
+		return nil
+	}
+	next := relaunchCommand(last)
+	next.RootProcess().Env = c.processEnv()
+	c.restarts.reset()
+	return c.launch(next)
+}
+
+// processEnv returns the environment of a new process. c.mu must be held.
+func (c *notifyCommand) processEnv() []string {
+	return append(withEnv(c.baseEnv, c.env...), "IBAZEL_NOTIFY_CHANGES=y")
+}
+
+func (c *notifyCommand) Signal(signum syscall.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running() {
+		return nil
+	}
+	return c.pg.Signal(signum)
+}
+
 func (c *notifyCommand) IsSubprocessRunning() bool {
-	return c.pg != nil && subprocessRunning(c.pg.RootProcess())
+	_, running := c.current()
+	return running
+}
+
+// current returns the current process and whether it is running. The process
+// group is safe to use without c.mu.
+func (c *notifyCommand) current() (process_group.ProcessGroup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pg, c.running()
+}
+
+// running reports whether the current process is running. c.mu must be held.
+func (c *notifyCommand) running() bool {
+	return c.pg != nil && processRunning(c.pg)
 }
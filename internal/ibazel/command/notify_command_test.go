@@ -161,4 +161,7 @@ func TestNotifyCommand_Restart(t *testing.T) {
 	if pid2 != c.pg.RootProcess().Process.Pid {
 		t.Error("non-dead process was restarted")
 	}
+
+	// Let the process finish before the test's logger goes away.
+	c.pg.Wait()
 }
@@ -0,0 +1,346 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+
+	// maxRestartBackoff caps the exponential backoff between crash restarts.
+	maxRestartBackoff = 30 * time.Second
+
+	// outputDrainTimeout bounds the wait for the output a process left in its
+	// pseudo-terminal, which a background child still using it keeps open.
+	outputDrainTimeout = time.Second
+)
+
+// ExitStatus describes how a run target's process exited.
+type ExitStatus struct {
+	// ExitCode is the exit code of the process, or -1 if it was killed by a
+	// signal.
+	ExitCode int
+	// Signal is the name of the signal that killed the process, if any.
+	Signal string
+	// Duration is how long the process ran for.
+	Duration time.Duration
+	// Requested is set when iBazel stopped the process, to restart it after a
+	// change or because it is exiting itself.
+	Requested bool
+}
+
+// Success reports whether the process exited cleanly.
+func (s ExitStatus) Success() bool {
+	return s.ExitCode == 0 && s.Signal == ""
+}
+
+// ExitHandler is called whenever a run target's process exits, including when
+// iBazel stopped it.
+type ExitHandler func(status ExitStatus)
+
+func newExitStatus(state *os.ProcessState, duration time.Duration) ExitStatus {
+	status := ExitStatus{ExitCode: -1, Duration: duration}
+	if state == nil {
+		return status
+	}
+	status.ExitCode = state.ExitCode()
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		status.Signal = ws.Signal().String()
+	}
+	return status
+}
+
+// monitoredProcessGroup waits on the wrapped process group in the background
+// as soon as it has started, so that a process exiting on its own is noticed
+// even when nobody is terminating it.
+type monitoredProcessGroup struct {
+	process_group.ProcessGroup
+
+	started  time.Time
+	expected atomic.Bool
+	stopping sync.Once // terminates the process only once
+	done     chan struct{}
+	err      error
+	onExit   func(pg *monitoredProcessGroup, status ExitStatus)
+
+	// stdout and stderr are where the output of the process goes before it is
+	// copied into a log or a pseudo-terminal, so that restarts can reuse them.
+	stdout io.Writer
+	stderr io.Writer
+	log    *runLog
+	output <-chan struct{} // closed once the pseudo-terminal output is copied
+}
+
+func monitor(pg process_group.ProcessGroup, onExit func(pg *monitoredProcessGroup, status ExitStatus)) *monitoredProcessGroup {
+	return &monitoredProcessGroup{
+		ProcessGroup: pg,
+		done:         make(chan struct{}),
+		onExit:       onExit,
+		stdout:       pg.RootProcess().Stdout,
+		stderr:       pg.RootProcess().Stderr,
+	}
+}
+
+// captureOutput copies the output of the process into a new log file of
+// logs. It must be called before the process is started.
+func (m *monitoredProcessGroup) captureOutput(logs *runLogs) {
+	if logs == nil {
+		return
+	}
+	l, err := logs.open()
+	if err != nil {
+		log.Errorf("Unable to create a log file: %v", err)
+		return
+	}
+	m.log = l
+	root := m.RootProcess()
+	root.Stdout = l.tee(root.Stdout)
+	root.Stderr = l.tee(root.Stderr)
+}
+
+func (m *monitoredProcessGroup) Start() error {
+	if err := m.ProcessGroup.Start(); err != nil {
+		m.err = err
+		if m.log != nil {
+			m.log.close(false)
+		}
+		close(m.done)
+		return err
+	}
+	m.started = time.Now()
+	go m.wait()
+	return nil
+}
+
+func (m *monitoredProcessGroup) wait() {
+	defer close(m.done)
+
+	m.err = m.ProcessGroup.Wait()
+	status := newExitStatus(m.RootProcess().ProcessState, time.Since(m.started))
+	status.Requested = m.expected.Load()
+	logUsage(m.ProcessGroup)
+	if m.output != nil {
+		select {
+		case <-m.output:
+		case <-time.After(outputDrainTimeout):
+		}
+	}
+	if m.log != nil {
+		m.log.close(!status.Requested && !status.Success())
+	}
+	if m.onExit != nil {
+		m.onExit(m, status)
+	}
+	if status.Requested {
+		// Whoever stops the process cleans up after it.
+		return
+	}
+	// Nobody terminates a process that exited on its own, so clean up after it
+	// here.
+	m.ProcessGroup.Close()
+}
+
+// logUsage logs the resources used by pg, if it keeps track of them.
+func logUsage(pg process_group.ProcessGroup) {
+	r, ok := pg.(process_group.UsageReporter)
+	if !ok {
+		return
+	}
+	u, err := r.Usage()
+	if err != nil {
+		return
+	}
+	if u.MemoryPeak > 0 {
+		log.Logf("The process used %s of CPU time and at most %.1f MiB of memory", u.CPUTime, float64(u.MemoryPeak)/(1<<20))
+	} else {
+		log.Logf("The process used %s of CPU time", u.CPUTime)
+	}
+}
+
+// Wait blocks until the process group has exited and its exit has been
+// handled. Unlike the wrapped implementation it is safe to call more than once.
+func (m *monitoredProcessGroup) Wait() error {
+	<-m.done
+	return m.err
+}
+
+// exited reports whether the process group has exited.
+func (m *monitoredProcessGroup) exited() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// running reports whether the process group has been started and hasn't
+// exited yet. Unlike the ProcessState of the root process, which is written by
+// the goroutine waiting on it, it is safe to call from any goroutine.
+func (m *monitoredProcessGroup) running() bool {
+	return !m.started.IsZero() && !m.exited()
+}
+
+// expectExit marks pg as being stopped by iBazel so that its exit is not
+// reported as a crash.
+func expectExit(pg process_group.ProcessGroup) {
+	if m, ok := pg.(*monitoredProcessGroup); ok {
+		m.expected.Store(true)
+	}
+}
+
+// errNotStarted is returned when restarting a target that was never started.
+var errNotStarted = errors.New("the target has not been started yet")
+
+// withEnv returns a copy of base followed by extra. Later entries override
+// earlier ones.
+func withEnv(base []string, extra ...string) []string {
+	env := make([]string, 0, len(base)+len(extra))
+	env = append(env, base...)
+	return append(env, extra...)
+}
+
+// relaunchCommand creates a fresh process group that runs the same program as
+// old. The run script produced by the last build is reused, so no rebuild
+// happens.
+func relaunchCommand(old *monitoredProcessGroup) process_group.ProcessGroup {
+	root := old.RootProcess()
+	pg := execCommand(root.Path, root.Args[1:]...)
+	pg.RootProcess().Stdout = old.stdout
+	pg.RootProcess().Stderr = old.stderr
+	pg.RootProcess().Dir = root.Dir
+	pg.RootProcess().Env = root.Env
+	pg.RootProcess().ExtraFiles = root.ExtraFiles
+	return pg
+}
+
+// restartPolicy decides whether a run target that exited on its own should be
+// started again, backing off exponentially between consecutive restarts.
+type restartPolicy struct {
+	mu       sync.Mutex
+	policy   string
+	max      int
+	backoff  time.Duration
+	attempts int
+}
+
+func newRestartPolicy(o Options) *restartPolicy {
+	policy := *restartPolicyFlag
+	if o.NoRestart {
+		policy = restartNever
+	}
+	switch policy {
+	case restartNever, restartOnFailure, restartAlways:
+	default:
+		log.Errorf("Unknown restart policy %q, falling back to %q", policy, restartNever)
+		policy = restartNever
+	}
+	return &restartPolicy{
+		policy:  policy,
+		max:     *restartMax,
+		backoff: *restartBackoff,
+	}
+}
+
+// next reports whether a process that exited with the given status should be
+// restarted and how long to wait before doing so.
+func (r *restartPolicy) next(status ExitStatus) (time.Duration, bool) {
+	if r == nil {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.policy {
+	case restartAlways:
+	case restartOnFailure:
+		if status.Success() {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if r.max > 0 && r.attempts >= r.max {
+		log.Errorf("Giving up after %d restarts. The process will be started again on the next change.", r.attempts)
+		return 0, false
+	}
+
+	delay := r.backoff
+	for n := 0; n < r.attempts && delay < maxRestartBackoff; n++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
+	}
+	r.attempts++
+	return delay, true
+}
+
+// reset is called whenever the process is started because of a change, which
+// gives it a fresh set of restart attempts.
+func (r *restartPolicy) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = 0
+}
+
+// handleExit logs that target's process exited on its own, reports it to
+// handler and, if the restart policy allows it, calls restart once the backoff
+// delay has elapsed.
+func handleExit(target string, status ExitStatus, handler ExitHandler, policy *restartPolicy, restart func()) {
+	if status.Requested {
+		if handler != nil {
+			handler(status)
+		}
+		return
+	}
+
+	log.NewLine()
+	ran := status.Duration.Round(time.Millisecond)
+	if status.Signal != "" {
+		log.Errorf("%s was killed by signal %s after running for %s", target, status.Signal, ran)
+	} else if status.Success() {
+		log.Logf("%s exited with code 0 after running for %s", target, ran)
+	} else {
+		log.Errorf("%s exited with code %d after running for %s", target, status.ExitCode, ran)
+	}
+
+	if handler != nil {
+		handler(status)
+	}
+
+	delay, ok := policy.next(status)
+	if !ok {
+		return
+	}
+	log.Logf("Restarting %s in %s", target, delay)
+	time.AfterFunc(delay, restart)
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	log.SetLogger(t)
+
+	failure := ExitStatus{ExitCode: 1}
+	success := ExitStatus{ExitCode: 0}
+
+	for _, c := range []struct {
+		policy string
+		status ExitStatus
+		want   bool
+	}{
+		{restartNever, failure, false},
+		{restartNever, success, false},
+		{restartOnFailure, failure, true},
+		{restartOnFailure, success, false},
+		{restartOnFailure, ExitStatus{ExitCode: -1, Signal: "killed"}, true},
+		{restartAlways, failure, true},
+		{restartAlways, success, true},
+	} {
+		r := &restartPolicy{policy: c.policy, backoff: time.Second}
+		if _, got := r.next(c.status); got != c.want {
+			t.Errorf("restartPolicy{%q}.next(%+v) = %v, want %v", c.policy, c.status, got, c.want)
+		}
+	}
+}
+
+func TestRestartPolicy_Backoff(t *testing.T) {
+	log.SetLogger(t)
+
+	r := &restartPolicy{policy: restartAlways, max: 7, backoff: time.Second}
+	for _, want := range []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		maxRestartBackoff,
+		maxRestartBackoff,
+	} {
+		got, ok := r.next(ExitStatus{ExitCode: 1})
+		if !ok {
+			t.Fatalf("next() gave up early")
+		}
+		if got != want {
+			t.Errorf("next() delay = %s, want %s", got, want)
+		}
+	}
+	if _, ok := r.next(ExitStatus{ExitCode: 1}); ok {
+		t.Errorf("next() should give up after %d restarts", r.max)
+	}
+
+	r.reset()
+	if got, ok := r.next(ExitStatus{ExitCode: 1}); !ok || got != time.Second {
+		t.Errorf("next() after reset() = %s, %v, want %s, true", got, ok, time.Second)
+	}
+}
+
+func TestMonitoredProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	log.SetLogger(t)
+
+	exited := make(chan ExitStatus, 1)
+	pg := monitor(process_group.Command("sh", "-c", "exit 3"), func(_ *monitoredProcessGroup, status ExitStatus) {
+		exited <- status
+	})
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	pg.Wait()
+	// Waiting twice must not block or fail differently.
+	pg.Wait()
+
+	select {
+	case status := <-exited:
+		if status.ExitCode != 3 || status.Success() || status.Requested {
+			t.Errorf("Got exit status %+v, want exit code 3", status)
+		}
+	default:
+		t.Errorf("The exit of the process was not reported")
+	}
+}
+
+func TestMonitoredProcessGroup_ExpectedExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	log.SetLogger(t)
+
+	exited := make(chan ExitStatus, 1)
+	pg := monitor(process_group.Command("sleep", "10"), func(_ *monitoredProcessGroup, status ExitStatus) {
+		exited <- status
+	})
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	terminate(pg, Options{})
+
+	select {
+	case status := <-exited:
+		if !status.Requested {
+			t.Errorf("Got exit status %+v, want it marked as requested", status)
+		}
+	default:
+		t.Errorf("The exit of the terminated process was not reported")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	runLogDir = flag.String(
+		"run_log_dir",
+		"",
+		"Directory to copy the output of every process of a run target into, one log file per process")
+	runLogKeep = flag.Int(
+		"run_log_keep",
+		10,
+		"Number of log files to keep per run target in --run_log_dir. 0 keeps all of them")
+)
+
+var unsafeLogChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// logName turns a target label into something usable as a file name, so that
+// //foo/bar:baz becomes foo_bar_baz.
+func logName(target string) string {
+	name := strings.Trim(unsafeLogChars.ReplaceAllString(target, "_"), "_")
+	if name == "" {
+		return "target"
+	}
+	return name
+}
+
+// runLogs hands out a log file to every process started for a target. The
+// files are named after the iteration of iBazel that started the process, and
+// only the most recent ones are kept.
+type runLogs struct {
+	dir  string
+	name string
+	keep int
+
+	mu        sync.Mutex
+	iteration int
+	started   int // processes started in this iteration
+}
+
+// newRunLogs returns nil unless --run_log_dir is set.
+func newRunLogs(target string) *runLogs {
+	if *runLogDir == "" {
+		return nil
+	}
+	return &runLogs{
+		dir:  *runLogDir,
+		name: logName(target),
+		keep: *runLogKeep,
+	}
+}
+
+// setIteration names the logs opened from now on after iteration.
+func (l *runLogs) setIteration(iteration int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if iteration != l.iteration {
+		l.iteration = iteration
+		l.started = 0
+	}
+}
+
+// files returns the existing log files, oldest first.
+func (l *runLogs) files() []string {
+	paths, _ := filepath.Glob(filepath.Join(l.dir, l.name+".*.log"))
+	modTimes := map[string]time.Time{}
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			modTimes[p] = info.ModTime()
+		}
+	}
+	sort.SliceStable(paths, func(a, b int) bool {
+		return modTimes[paths[a]].Before(modTimes[paths[b]])
+	})
+	return paths
+}
+
+// path returns the log file of the current process: <name>.<iteration>.log
+// for the first process of an iteration, and <name>.<iteration>.<n>.log for
+// the nth crash restart within it.
+func (l *runLogs) path() string {
+	if l.started == 0 {
+		return filepath.Join(l.dir, fmt.Sprintf("%s.%d.log", l.name, l.iteration))
+	}
+	return filepath.Join(l.dir, fmt.Sprintf("%s.%d.%d.log", l.name, l.iteration, l.started))
+}
+
+// open creates the log file of the next process. A file left behind by an
+// earlier iBazel for the same iteration is replaced.
+func (l *runLogs) open() (*runLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	path := l.path()
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l.started++
+
+	if l.keep > 0 {
+		var old []string
+		for _, p := range l.files() {
+			if p != path {
+				old = append(old, p)
+			}
+		}
+		for len(old) >= l.keep {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+	return &runLog{file: f, crash: filepath.Join(l.dir, l.name+".last_crash")}, nil
+}
+
+// runLog is the log file of a single process.
+type runLog struct {
+	file  *os.File
+	crash string
+}
+
+// tee returns a writer that copies everything written to w into the log.
+func (r *runLog) tee(w io.Writer) io.Writer {
+	if w == nil {
+		return r.file
+	}
+	return &logWriter{w: w, log: r.file}
+}
+
+// close closes the log file and, if the process crashed, points the last
+// crash file at it.
+func (r *runLog) close(crashed bool) {
+	r.file.Close()
+	if crashed {
+		os.WriteFile(r.crash, []byte(r.file.Name()+"\n"), 0644)
+	}
+}
+
+// logWriter writes to w and to log. Failing to write the log does not fail
+// the write, so a full disk does not cut off the output of the process.
+type logWriter struct {
+	w   io.Writer
+	log io.Writer
+}
+
+func (t *logWriter) Write(p []byte) (int, error) {
+	t.log.Write(p)
+	return t.w.Write(p)
+}
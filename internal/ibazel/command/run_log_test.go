@@ -0,0 +1,159 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+func TestLogName(t *testing.T) {
+	for target, want := range map[string]string{
+		"//foo/bar:baz":  "foo_bar_baz",
+		"@repo//:server": "repo_server",
+		"//a:b.c-d":      "a_b.c-d",
+		"//:":            "target",
+	} {
+		if got := logName(target); got != want {
+			t.Errorf("logName(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestRunLogs_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	// A log left behind by an earlier iBazel.
+	old := filepath.Join(dir, "foo_bar.9.log")
+	os.WriteFile(old, nil, 0644)
+	os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	logs := &runLogs{dir: dir, name: "foo_bar", keep: 3}
+	open := func() {
+		l, err := logs.open()
+		if err != nil {
+			t.Fatalf("open(): %v", err)
+		}
+		l.close(false)
+		// Keep the modification times apart on coarse file systems.
+		time.Sleep(10 * time.Millisecond)
+	}
+	logs.setIteration(1)
+	open()
+	logs.setIteration(2)
+	open()
+	// A crash restart within the same iteration.
+	open()
+	logs.setIteration(3)
+	open()
+
+	var got []string
+	for _, p := range logs.files() {
+		got = append(got, filepath.Base(p))
+	}
+	if want := []string{"foo_bar.2.log", "foo_bar.2.1.log", "foo_bar.3.log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files() = %v, want %v", got, want)
+	}
+}
+
+func TestMonitoredProcessGroup_CaptureOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	log.SetLogger(t)
+
+	dir := t.TempDir()
+	logs := &runLogs{dir: dir, name: "crasher"}
+	logs.setIteration(7)
+
+	var stdout bytes.Buffer
+	pg := process_group.Command("sh", "-c", "echo hello; echo oops >&2; exit 1")
+	pg.RootProcess().Stdout = &stdout
+	m := monitor(pg, nil)
+	m.captureOutput(logs)
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	m.Wait()
+
+	if got := stdout.String(); got != "hello\n" {
+		t.Errorf("stdout = %q, want %q", got, "hello\n")
+	}
+	logFile := filepath.Join(dir, "crasher.7.log")
+	contents, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Reading the log: %v", err)
+	}
+	if !strings.Contains(string(contents), "hello") || !strings.Contains(string(contents), "oops") {
+		t.Errorf("The log %q is missing output", contents)
+	}
+
+	crash, err := os.ReadFile(filepath.Join(dir, "crasher.last_crash"))
+	if err != nil {
+		t.Fatalf("Reading the last crash file: %v", err)
+	}
+	if got := strings.TrimSpace(string(crash)); got != logFile {
+		t.Errorf("The last crash file points at %q, want %q", got, logFile)
+	}
+
+	// The restarted process writes to the original stdout, not to the old log.
+	if next := relaunchCommand(m); next.RootProcess().Stdout != &stdout {
+		t.Errorf("relaunchCommand() did not reuse the original stdout")
+	}
+}
+
+func TestMonitoredProcessGroup_WaitsForTerminalOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	log.SetLogger(t)
+
+	dir := t.TempDir()
+	logs := &runLogs{dir: dir, name: "crasher"}
+
+	pg := process_group.Command("sh", "-c", "exit 1")
+	m := monitor(pg, nil)
+	m.captureOutput(logs)
+	// Stands in for the copy of the pseudo-terminal, which still has output
+	// buffered when the process exits.
+	out := m.RootProcess().Stdout
+	copied := make(chan struct{})
+	m.output = copied
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		out.Write([]byte("last words\n"))
+		close(copied)
+	}()
+	m.Wait()
+
+	contents, err := os.ReadFile(filepath.Join(dir, "crasher.0.log"))
+	if err != nil {
+		t.Fatalf("Reading the log: %v", err)
+	}
+	if !strings.Contains(string(contents), "last words") {
+		t.Errorf("The log %q is missing the output copied after the exit", contents)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/process_group"
+)
+
+// terminal forwards the input and the window size of the terminal of iBazel
+// to the pseudo-terminal of the current process. Processes come and go with
+// every restart while stdin can only be read by one reader, so the forwarding
+// is shared by all of them.
+var terminal struct {
+	mu    sync.Mutex
+	pty   *os.File
+	start sync.Once
+}
+
+// attachTerminal runs pg on a new pseudo-terminal and copies its output to
+// the stdout pg was given. It must be called before pg is started. The
+// returned channel is closed once all the output has been copied, and is nil
+// if pg runs without a pseudo-terminal.
+func attachTerminal(pg process_group.ProcessGroup) <-chan struct{} {
+	out := pg.RootProcess().Stdout
+	pty, err := process_group.AttachTerminal(pg)
+	if err != nil {
+		log.Errorf("Unable to run the process on a pseudo-terminal: %v", err)
+		return nil
+	}
+	process_group.InheritWindowSize(pty, os.Stdout)
+
+	terminal.start.Do(func() {
+		go forwardStdin()
+		go forwardWindowSize()
+	})
+	terminal.mu.Lock()
+	terminal.pty = pty
+	terminal.mu.Unlock()
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		// Reading fails once every process using the pseudo-terminal is gone.
+		io.Copy(out, pty)
+		terminal.mu.Lock()
+		if terminal.pty == pty {
+			terminal.pty = nil
+		}
+		terminal.mu.Unlock()
+		pty.Close()
+	}()
+	return copied
+}
+
+func forwardStdin() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			terminal.mu.Lock()
+			if terminal.pty != nil {
+				terminal.pty.Write(buf[:n])
+			}
+			terminal.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func resizeTerminal() {
+	terminal.mu.Lock()
+	defer terminal.mu.Unlock()
+	if terminal.pty != nil {
+		process_group.InheritWindowSize(terminal.pty, os.Stdout)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dotenv reads environment variables from .env files.
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Env is an ordered set of environment variables.
+type Env struct {
+	keys   []string
+	values map[string]string
+}
+
+// Get returns the value of key, if it is set.
+func (e *Env) Get(key string) (string, bool) {
+	v, ok := e.values[key]
+	return v, ok
+}
+
+func (e *Env) set(key, value string) {
+	if e.values == nil {
+		e.values = map[string]string{}
+	}
+	if _, ok := e.values[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.values[key] = value
+}
+
+// Environ returns the variables as KEY=value pairs, in the order they were
+// first set.
+func (e *Env) Environ() []string {
+	environ := make([]string, 0, len(e.keys))
+	for _, k := range e.keys {
+		environ = append(environ, k+"="+e.values[k])
+	}
+	return environ
+}
+
+// Load reads the given files in order. Variables set by a later file override
+// the ones set by an earlier one.
+func Load(paths ...string) (*Env, error) {
+	env := &Env{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = parse(f, env)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return env, nil
+}
+
+// Parse reads dotenv syntax from r:
+//
+//	# Comments and blank lines are ignored.
+//	export KEY=value
+//	UNQUOTED=value # with a comment
+//	SINGLE='taken $literally'
+//	DOUBLE="with\tescapes and ${KEY}"
+//
+// Unquoted and double quoted values expand $VAR and ${VAR} from the variables
+// set earlier and from the environment of iBazel.
+func Parse(r io.Reader) (*Env, error) {
+	env := &Env{}
+	if err := parse(r, env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func parse(r io.Reader, env *Env) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !keyPattern.MatchString(key) {
+			return fmt.Errorf("line %d: invalid variable name %q", n, key)
+		}
+		value, err := parseValue(strings.TrimSpace(line[eq+1:]), env)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		env.set(key, value)
+	}
+	return scanner.Err()
+}
+
+func parseValue(raw string, env *Env) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		if err := checkTrailing(raw[end+2:]); err != nil {
+			return "", err
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var value strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch c := raw[i]; c {
+			case '"':
+				if err := checkTrailing(raw[i+1:]); err != nil {
+					return "", err
+				}
+				return expand(value.String(), env), nil
+			case '\\':
+				i++
+				if i == len(raw) {
+					continue
+				}
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 'r':
+					value.WriteByte('\r')
+				case 't':
+					value.WriteByte('\t')
+				case '$':
+					// Keep the escape so that expand leaves the dollar alone.
+					value.WriteString("$$")
+				default:
+					value.WriteByte(raw[i])
+				}
+			default:
+				value.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+	}
+
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return expand(strings.TrimSpace(raw), env), nil
+}
+
+// checkTrailing allows nothing but a comment after a quoted value.
+func checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+	return nil
+}
+
+func expand(value string, env *Env) string {
+	return os.Expand(value, func(key string) string {
+		if key == "$" {
+			return "$"
+		}
+		if v, ok := env.Get(key); ok {
+			return v
+		}
+		return os.Getenv(key)
+	})
+}
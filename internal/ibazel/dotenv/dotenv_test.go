@@ -0,0 +1,93 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotenv
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	os.Setenv("DOTENV_TEST_HOME", "/home/me")
+	defer os.Unsetenv("DOTENV_TEST_HOME")
+
+	env, err := Parse(strings.NewReader(`
+# A comment
+PORT=8080
+export HOST = localhost
+URL=http://${HOST}:$PORT/ # trailing comment
+HASH=a#b
+EMPTY=
+SINGLE='$PORT stays # as is'
+DOUBLE="line\nbreak \"quoted\" \$PORT"
+DIR="$DOTENV_TEST_HOME/data"
+PORT=9090
+`))
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+
+	want := []string{
+		"PORT=9090",
+		"HOST=localhost",
+		"URL=http://localhost:8080/",
+		"HASH=a#b",
+		"EMPTY=",
+		"SINGLE=$PORT stays # as is",
+		"DOUBLE=line\nbreak \"quoted\" $PORT",
+		"DIR=/home/me/data",
+	}
+	if got := env.Environ(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q, want %q", got, want)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, input := range []string{
+		"NOVALUE",
+		"1KEY=value",
+		"KEY='unterminated",
+		"KEY=\"unterminated",
+		"KEY=\"quoted\" trailing",
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) should have failed", input)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	os.WriteFile(base, []byte("NAME=base\nGREETING=hello $NAME\n"), 0644)
+	os.WriteFile(local, []byte("NAME=local\nFROM_BASE=$GREETING\n"), 0644)
+
+	env, err := Load(base, local)
+	if err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	want := []string{"NAME=local", "GREETING=hello base", "FROM_BASE=hello base"}
+	if got := env.Environ(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q, want %q", got, want)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Load() of a missing file should have failed")
+	}
+}
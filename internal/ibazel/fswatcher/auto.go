@@ -0,0 +1,152 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fswatcher
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+)
+
+// autoWatcher watches names with the native watcher, except for the ones on
+// file systems that don't report changes, which are polled. The workspace,
+// local repositories and bind mounted packages may each be on a different
+// file system.
+type autoWatcher struct {
+	native     common.Watcher
+	newPolling func() (common.Watcher, error)
+	unreliable func(path string) (string, bool)
+
+	mu      sync.Mutex
+	polling common.Watcher
+
+	events   chan common.Event // native and polled events
+	forwards sync.WaitGroup
+}
+
+var _ common.Watcher = &autoWatcher{}
+
+// reportedFileSystems are the unreliable file systems already logged about, by
+// any of the watchers.
+var reportedFileSystems sync.Map
+
+func newAutoWatcher(native common.Watcher, newPolling func() (common.Watcher, error), unreliable func(path string) (string, bool)) *autoWatcher {
+	w := &autoWatcher{
+		native:     native,
+		newPolling: newPolling,
+		unreliable: unreliable,
+		events:     make(chan common.Event),
+	}
+	w.forward(native.Events())
+	return w
+}
+
+// UpdateAll implements ibazel/fswatcher/common.Watcher
+func (w *autoWatcher) UpdateAll(names []string) error {
+	var native, polled []string
+	fsTypes := map[string]string{}
+	for _, name := range names {
+		if fsType, ok := w.unreliable(name); ok {
+			polled = append(polled, name)
+			fsTypes[fsType] = name
+		} else {
+			native = append(native, name)
+		}
+	}
+
+	var errs []string
+	if err := w.native.UpdateAll(native); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := w.poll(polled); err != nil {
+		errs = append(errs, err.Error())
+	}
+	w.report(fsTypes)
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// poll hands names to the polling watcher, starting it the first time a name
+// is on an unreliable file system.
+func (w *autoWatcher) poll(names []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.polling == nil {
+		if len(names) == 0 {
+			return nil
+		}
+		polling, err := w.newPolling()
+		if err != nil {
+			return err
+		}
+		w.polling = polling
+		w.forward(polling.Events())
+	}
+	return w.polling.UpdateAll(names)
+}
+
+// report logs the unreliable file systems seen for the first time, with one
+// of the names on each.
+func (w *autoWatcher) report(fsTypes map[string]string) {
+	var types []string
+	for fsType := range fsTypes {
+		types = append(types, fsType)
+	}
+	sort.Strings(types)
+	for _, fsType := range types {
+		if _, loaded := reportedFileSystems.LoadOrStore(fsType, true); loaded {
+			continue
+		}
+		log.Logf("%s is on %s, which doesn't report file changes. Polling for changes there every %s instead. Use --watcher=native to override.", fsTypes[fsType], fsType, *pollInterval)
+	}
+}
+
+// forward copies events from c to the merged events channel until c closes.
+func (w *autoWatcher) forward(c chan common.Event) {
+	w.forwards.Add(1)
+	go func() {
+		defer w.forwards.Done()
+		for e := range c {
+			w.events <- e
+		}
+	}()
+}
+
+// Close implements ibazel/fswatcher/common.Watcher
+func (w *autoWatcher) Close() error {
+	err := w.native.Close()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.polling != nil {
+		w.polling.Close()
+	}
+	go func() {
+		w.forwards.Wait()
+		close(w.events)
+	}()
+	return err
+}
+
+// Events implements ibazel/fswatcher/common.Watcher
+func (w *autoWatcher) Events() chan common.Event {
+	return w.events
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fswatcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+type fakeWatcher struct {
+	names  []string
+	events chan common.Event
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{events: make(chan common.Event)}
+}
+
+func (w *fakeWatcher) UpdateAll(names []string) error {
+	w.names = names
+	return nil
+}
+
+func (w *fakeWatcher) Close() error {
+	close(w.events)
+	return nil
+}
+
+func (w *fakeWatcher) Events() chan common.Event {
+	return w.events
+}
+
+func TestAutoWatcher(t *testing.T) {
+	native := newFakeWatcher()
+	var polling *fakeWatcher
+	newPolling := func() (common.Watcher, error) {
+		polling = newFakeWatcher()
+		return polling, nil
+	}
+	// /nfs is a network mount inside the workspace, /repo a local repository
+	// on virtiofs.
+	unreliable := func(path string) (string, bool) {
+		switch {
+		case strings.HasPrefix(path, "/ws/nfs"):
+			return "NFS", true
+		case strings.HasPrefix(path, "/repo"):
+			return "virtiofs", true
+		}
+		return "", false
+	}
+	w := newAutoWatcher(native, newPolling, unreliable)
+
+	if err := w.UpdateAll([]string{"/ws", "/ws/src"}); err != nil {
+		t.Fatalf("UpdateAll(): %v", err)
+	}
+	if polling != nil {
+		t.Errorf("Nothing should be polled on local file systems")
+	}
+
+	if err := w.UpdateAll([]string{"/ws", "/ws/nfs/pkg", "/repo/lib"}); err != nil {
+		t.Fatalf("UpdateAll(): %v", err)
+	}
+	if want := []string{"/ws"}; !reflect.DeepEqual(native.names, want) {
+		t.Errorf("Native watcher watches %v, want %v", native.names, want)
+	}
+	if want := []string{"/ws/nfs/pkg", "/repo/lib"}; polling == nil || !reflect.DeepEqual(polling.names, want) {
+		t.Fatalf("Polling watcher watches %v, want %v", polling, want)
+	}
+
+	for _, c := range []chan common.Event{native.events, polling.events} {
+		want := common.Event{Name: "/ws/changed.go", Op: common.Write}
+		c <- want
+		select {
+		case e := <-w.Events():
+			if e != want {
+				t.Errorf("Got event %v, want %v", e, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %v", want)
+		}
+	}
+
+	if err := w.UpdateAll([]string{"/ws"}); err != nil {
+		t.Fatalf("UpdateAll(): %v", err)
+	}
+	if len(polling.names) != 0 {
+		t.Errorf("Polling watcher still watches %v", polling.names)
+	}
+
+	w.Close()
+	for range w.Events() {
+		// Drain until the events channel is closed.
+	}
+}
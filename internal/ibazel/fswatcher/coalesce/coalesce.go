@@ -0,0 +1,190 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coalesce merges the events a watcher reports for one logical change
+// to a file, such as an editor's atomic save, into a single event.
+package coalesce
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+type coalescingWatcher struct {
+	watcher common.Watcher
+	window  time.Duration
+	events  chan common.Event
+
+	// Events received but not yet sent, in the order their names were first
+	// seen.
+	pending []*common.Event
+	byName  map[string]*common.Event
+	// renamed holds the names moved away from that don't have a destination
+	// yet.
+	renamed []string
+}
+
+var _ common.Watcher = &coalescingWatcher{}
+
+// New returns a watcher that sends the events of w once no more than window
+// after the first of them. Within a window, the operations on each file are
+// merged, a file moved away from is paired with the file then moved to in the
+// same directory, and files that are created and removed again are dropped.
+func New(w common.Watcher, window time.Duration) common.Watcher {
+	c := &coalescingWatcher{
+		watcher: w,
+		window:  window,
+		events:  make(chan common.Event),
+		byName:  map[string]*common.Event{},
+	}
+	go c.run()
+	return c
+}
+
+func (c *coalescingWatcher) run() {
+	defer close(c.events)
+
+	var flush <-chan time.Time
+	for {
+		select {
+		case e, ok := <-c.watcher.Events():
+			if !ok {
+				c.send(c.take())
+				return
+			}
+			c.add(e)
+			if flush == nil {
+				flush = time.After(c.window)
+			}
+		case <-flush:
+			flush = nil
+			c.send(c.take())
+		}
+	}
+}
+
+// add merges e into the pending events.
+func (c *coalescingWatcher) add(e common.Event) {
+	if e.Has(common.Rename) && e.OldName == "" {
+		if _, err := os.Lstat(e.Name); err != nil {
+			// The file was moved away from e.Name. Wait for the file it was moved
+			// to, which inotify reports as created.
+			c.renamed = append(c.renamed, e.Name)
+			c.merge(e)
+			return
+		}
+		// Some watchers report both ends of a rename as renamed, this is the end
+		// that exists.
+		e.Op &^= common.Rename
+		e.Op |= common.Create
+	}
+	if e.Has(common.Create) && e.OldName == "" {
+		if from, ok := c.renamedFrom(e.Name); ok {
+			e.OldName = from
+			e.Op |= common.Rename
+			// The file moved away from is reported as the OldName of this one.
+			c.drop(e.OldName)
+		}
+	}
+	c.merge(e)
+}
+
+// renamedFrom takes the oldest name moved away from in the directory of name,
+// which is what an editor's atomic save or a rename within a directory looks
+// like. Files moved to another directory aren't paired, as the events of
+// unrelated renames in different directories may interleave.
+func (c *coalescingWatcher) renamedFrom(name string) (string, bool) {
+	dir := filepath.Dir(name)
+	for i, from := range c.renamed {
+		if filepath.Dir(from) == dir {
+			c.renamed = append(c.renamed[:i], c.renamed[i+1:]...)
+			return from, true
+		}
+	}
+	return "", false
+}
+
+// merge adds the operations of e to the pending event for e.Name.
+func (c *coalescingWatcher) merge(e common.Event) {
+	p, ok := c.byName[e.Name]
+	if !ok {
+		p = &common.Event{Name: e.Name}
+		c.byName[e.Name] = p
+		c.pending = append(c.pending, p)
+	}
+	if p.Has(common.Create) && e.Has(common.Remove) && e.OldName == "" {
+		// A temporary file that came and went.
+		c.drop(e.Name)
+		return
+	}
+	if e.Has(common.Create) && p.Has(common.Remove) {
+		// Removed and written again, like some editors save.
+		p.Op &^= common.Remove
+		e.Op &^= common.Create
+		e.Op |= common.Write
+	}
+	p.Op |= e.Op
+	if e.OldName != "" {
+		p.OldName = e.OldName
+	}
+}
+
+func (c *coalescingWatcher) drop(name string) {
+	p := c.byName[name]
+	delete(c.byName, name)
+	for i, q := range c.pending {
+		if q == p {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// take returns the pending events and starts over.
+func (c *coalescingWatcher) take() []common.Event {
+	var events []common.Event
+	for _, p := range c.pending {
+		if p.Op != 0 {
+			events = append(events, *p)
+		}
+	}
+	c.pending = nil
+	c.byName = map[string]*common.Event{}
+	c.renamed = nil
+	return events
+}
+
+func (c *coalescingWatcher) send(events []common.Event) {
+	for _, e := range events {
+		c.events <- e
+	}
+}
+
+// UpdateAll implements ibazel/fswatcher/common.Watcher
+func (c *coalescingWatcher) UpdateAll(names []string) error {
+	return c.watcher.UpdateAll(names)
+}
+
+// Close implements ibazel/fswatcher/common.Watcher
+func (c *coalescingWatcher) Close() error {
+	return c.watcher.Close()
+}
+
+// Events implements ibazel/fswatcher/common.Watcher
+func (c *coalescingWatcher) Events() chan common.Event {
+	return c.events
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coalesce
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+type fakeWatcher struct {
+	events chan common.Event
+}
+
+func (w *fakeWatcher) UpdateAll(names []string) error { return nil }
+func (w *fakeWatcher) Close() error                   { close(w.events); return nil }
+func (w *fakeWatcher) Events() chan common.Event      { return w.events }
+
+// coalesce sends events through a coalescing watcher and returns what comes
+// out once it is closed.
+func coalesce(t *testing.T, events ...common.Event) []common.Event {
+	t.Helper()
+	fake := &fakeWatcher{events: make(chan common.Event)}
+	w := New(fake, time.Hour)
+	go func() {
+		for _, e := range events {
+			fake.events <- e
+		}
+		w.Close()
+	}()
+
+	var got []common.Event
+	for e := range w.Events() {
+		got = append(got, e)
+	}
+	return got
+}
+
+func assertEvents(t *testing.T, want, got []common.Event) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Errorf("event %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func touch(t *testing.T, name string) {
+	t.Helper()
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergesOps(t *testing.T) {
+	got := coalesce(t,
+		common.Event{Name: "a", Op: common.Write},
+		common.Event{Name: "b", Op: common.Write},
+		common.Event{Name: "a", Op: common.Write},
+		common.Event{Name: "a", Op: common.Chmod},
+	)
+	assertEvents(t, []common.Event{
+		{Name: "a", Op: common.Write | common.Chmod},
+		{Name: "b", Op: common.Write},
+	}, got)
+}
+
+func TestAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, ".main.go.tmp")
+	file := filepath.Join(dir, "main.go")
+	touch(t, file)
+
+	got := coalesce(t,
+		common.Event{Name: tmp, Op: common.Create},
+		common.Event{Name: tmp, Op: common.Write},
+		common.Event{Name: tmp, Op: common.Rename},
+		common.Event{Name: file, Op: common.Create},
+		common.Event{Name: file, Op: common.Chmod},
+	)
+	assertEvents(t, []common.Event{
+		{Name: file, OldName: tmp, Op: common.Create | common.Rename | common.Chmod},
+	}, got)
+}
+
+func TestRenamePair(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	to := filepath.Join(dir, "to")
+	touch(t, to)
+
+	// Both ends reported as renamed, like FSEvents does.
+	got := coalesce(t,
+		common.Event{Name: from, Op: common.Rename},
+		common.Event{Name: to, Op: common.Rename},
+	)
+	assertEvents(t, []common.Event{
+		{Name: to, OldName: from, Op: common.Create | common.Rename},
+	}, got)
+}
+
+func TestInterleavedRenames(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	tmpA := filepath.Join(a, ".x.tmp")
+	x := filepath.Join(a, "x")
+	tmpB := filepath.Join(b, ".y.tmp")
+	y := filepath.Join(b, "y")
+	touch(t, x)
+	touch(t, y)
+
+	// Two atomic saves in different directories, whose destinations are
+	// reported in the opposite order.
+	got := coalesce(t,
+		common.Event{Name: tmpA, Op: common.Rename},
+		common.Event{Name: tmpB, Op: common.Rename},
+		common.Event{Name: y, Op: common.Create},
+		common.Event{Name: x, Op: common.Create},
+	)
+	assertEvents(t, []common.Event{
+		{Name: y, OldName: tmpB, Op: common.Create | common.Rename},
+		{Name: x, OldName: tmpA, Op: common.Create | common.Rename},
+	}, got)
+}
+
+func TestMoveToOtherDirectory(t *testing.T) {
+	from := filepath.Join(t.TempDir(), "from")
+	to := filepath.Join(t.TempDir(), "to")
+	touch(t, to)
+
+	// Not paired, since it may as well be an unrelated file created elsewhere.
+	got := coalesce(t,
+		common.Event{Name: from, Op: common.Rename},
+		common.Event{Name: to, Op: common.Create},
+	)
+	assertEvents(t, []common.Event{
+		{Name: from, Op: common.Rename},
+		{Name: to, Op: common.Create},
+	}, got)
+}
+
+func TestRenameOutOfTree(t *testing.T) {
+	gone := filepath.Join(t.TempDir(), "gone")
+	got := coalesce(t, common.Event{Name: gone, Op: common.Rename})
+	assertEvents(t, []common.Event{{Name: gone, Op: common.Rename}}, got)
+}
+
+func TestTemporaryFileDropped(t *testing.T) {
+	got := coalesce(t,
+		common.Event{Name: "a~", Op: common.Create},
+		common.Event{Name: "a~", Op: common.Write},
+		common.Event{Name: "a", Op: common.Write},
+		common.Event{Name: "a~", Op: common.Remove},
+	)
+	assertEvents(t, []common.Event{{Name: "a", Op: common.Write}}, got)
+}
+
+func TestRemovedAndRecreated(t *testing.T) {
+	got := coalesce(t,
+		common.Event{Name: "a", Op: common.Remove},
+		common.Event{Name: "a", Op: common.Create},
+	)
+	assertEvents(t, []common.Event{{Name: "a", Op: common.Write}}, got)
+}
+
+func TestWindow(t *testing.T) {
+	fake := &fakeWatcher{events: make(chan common.Event)}
+	w := New(fake, 10*time.Millisecond)
+	defer w.Close()
+
+	fake.events <- common.Event{Name: "a", Op: common.Write}
+	fake.events <- common.Event{Name: "a", Op: common.Write}
+	select {
+	case e := <-w.Events():
+		assertEvents(t, []common.Event{{Name: "a", Op: common.Write}}, []common.Event{e})
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event after the window")
+	}
+}
+
+func TestContentChanged(t *testing.T) {
+	for _, c := range []struct {
+		op   common.Op
+		want bool
+	}{
+		{common.Chmod, false},
+		{common.Write, true},
+		{common.Write | common.Chmod, true},
+		{common.Rename, true},
+		{common.Remove, true},
+	} {
+		if got := (common.Event{Name: "a", Op: c.op}).ContentChanged(); got != c.want {
+			t.Errorf("ContentChanged() for %s = %v, want %v", c.op, got, c.want)
+		}
+	}
+}
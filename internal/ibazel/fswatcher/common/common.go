@@ -15,10 +15,11 @@
 package common
 
 import (
+	"fmt"
+
 	"github.com/fsnotify/fsnotify"
 )
 
-type Event = fsnotify.Event
 type Op = fsnotify.Op
 
 const Create = fsnotify.Create
@@ -27,6 +28,43 @@ const Remove = fsnotify.Remove
 const Rename = fsnotify.Rename
 const Chmod = fsnotify.Chmod
 
+// Event is a change to a file reported by a Watcher.
+type Event struct {
+	// Name is the path of the file that changed. For a rename it is the new
+	// path.
+	Name string
+	// Op is the set of operations made on the file.
+	Op Op
+	// OldName is the path the file was renamed from, if known.
+	OldName string
+}
+
+// Has reports whether e includes op.
+func (e Event) Has(op Op) bool {
+	return e.Op&op != 0
+}
+
+// ContentChanged reports whether the file was written, created, removed or
+// renamed, as opposed to only having its metadata changed.
+func (e Event) ContentChanged() bool {
+	return e.Op&^Chmod != 0
+}
+
+// Names returns the paths touched by e.
+func (e Event) Names() []string {
+	if e.OldName == "" {
+		return []string{e.Name}
+	}
+	return []string{e.Name, e.OldName}
+}
+
+func (e Event) String() string {
+	if e.OldName == "" {
+		return fmt.Sprintf("%q: %s", e.Name, e.Op)
+	}
+	return fmt.Sprintf("%q -> %q: %s", e.OldName, e.Name, e.Op)
+}
+
 type Watcher interface {
 	Close() error
 	UpdateAll(name []string) error
@@ -0,0 +1,83 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fswatcher
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/coalesce"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/polling"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/watchman"
+)
+
+var (
+	watcherFlag = flag.String(
+		"watcher",
+		"auto",
+		"How to watch files for changes: native, polling, watchman, or auto to poll on file systems that don't report changes, such as NFS (detected on Linux only)")
+	pollInterval = flag.Duration(
+		"watcher_poll_interval",
+		time.Second,
+		"How often the polling watcher looks for changes")
+	watchmanSocket = flag.String(
+		"watchman_socket",
+		"",
+		"Socket of the watchman daemon used by --watcher=watchman. Defaults to $WATCHMAN_SOCK or asking the watchman CLI")
+)
+
+// coalesceWindow is how long the events for one change, such as an editor's
+// atomic save, are gathered into one.
+const coalesceWindow = 50 * time.Millisecond
+
+// NewWatcher returns a watcher of the kind selected with --watcher.
+func NewWatcher() (common.Watcher, error) {
+	w, err := newWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return coalesce.New(w, coalesceWindow), nil
+}
+
+func newWatcher() (common.Watcher, error) {
+	switch *watcherFlag {
+	case "native":
+		return newNativeWatcher()
+	case "polling":
+		return polling.NewWatcher(*pollInterval)
+	case "watchman":
+		sockname := *watchmanSocket
+		if sockname == "" {
+			var err error
+			if sockname, err = watchman.Sockname(); err != nil {
+				return nil, err
+			}
+		}
+		return watchman.NewWatcher(sockname)
+	case "auto":
+		native, err := newNativeWatcher()
+		if err != nil {
+			return nil, err
+		}
+		newPolling := func() (common.Watcher, error) {
+			return polling.NewWatcher(*pollInterval)
+		}
+		return newAutoWatcher(native, newPolling, unreliableFileSystem), nil
+	default:
+		return nil, fmt.Errorf("unknown --watcher %q, expected native, polling, watchman or auto", *watcherFlag)
+	}
+}
@@ -25,10 +25,10 @@ import (
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/fsnotify"
 )
 
-func NewWatcher() (common.Watcher, error) {
+func newNativeWatcher() (common.Watcher, error) {
 	flag, ok := os.LookupEnv("IBAZEL_USE_LEGACY_WATCHER")
 	if ok && flag != "0" {
-		return fsnotify.NewWatcher()
+		return fsnotify.NewWatcher(*pollInterval)
 	}
 
 	return fsevents.NewWatcher()
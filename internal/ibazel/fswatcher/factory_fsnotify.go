@@ -22,6 +22,6 @@ import (
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/fsnotify"
 )
 
-func NewWatcher() (common.Watcher, error) {
-	return fsnotify.NewWatcher()
+func newNativeWatcher() (common.Watcher, error) {
+	return fsnotify.NewWatcher(*pollInterval)
 }
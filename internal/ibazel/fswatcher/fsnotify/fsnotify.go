@@ -18,10 +18,15 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/polling"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 )
 
 // We have to declare our own partial interface in order to mock it out in test
@@ -44,6 +49,16 @@ func (w *fsNotifyWatcherWrapper) Events() chan fsnotify.Event { return w.watcher
 type realFSNotifyWatcher struct {
 	watched map[string]struct{}
 	wrapper fsNotifyWatcher
+
+	// Directories that couldn't get an inotify watch because the kernel limit
+	// was reached are polled instead.
+	pollInterval time.Duration
+	overflow     common.Watcher
+	polled       int
+
+	mu       sync.Mutex
+	events   chan common.Event // inotify and polled events
+	forwards sync.WaitGroup
 }
 
 var _ common.Watcher = &realFSNotifyWatcher{}
@@ -51,6 +66,7 @@ var _ common.Watcher = &realFSNotifyWatcher{}
 // UpdateAll implements ibazel/fswatcher/common.Watcher
 func (w *realFSNotifyWatcher) UpdateAll(names []string) error {
 	var errs []string
+	var overflow []string
 	prev_watched := w.watched
 	new_watched := make(map[string]struct{}, len(names))
 
@@ -62,7 +78,10 @@ func (w *realFSNotifyWatcher) UpdateAll(names []string) error {
 			delete(w.watched, name)
 		} else {
 			err := w.wrapper.Add(name)
-			if err != nil {
+			if isWatchLimit(err) {
+				delete(new_watched, name)
+				overflow = append(overflow, name)
+			} else if err != nil {
 				errs = append(errs, fmt.Sprintf("Error watching file %q error: %v", name, err))
 			}
 		}
@@ -77,24 +96,118 @@ func (w *realFSNotifyWatcher) UpdateAll(names []string) error {
 
 	w.watched = new_watched
 
+	if err := w.poll(overflow); err != nil {
+		errs = append(errs, err.Error())
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, "\n"))
 	}
 	return nil
 }
 
+// isWatchLimit reports whether err means fs.inotify.max_user_watches has been
+// exhausted.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// poll hands names to the polling watcher, starting it the first time a
+// directory overflows, and reports when the split between backends changes.
+func (w *realFSNotifyWatcher) poll(names []string) error {
+	if w.overflow == nil {
+		if len(names) == 0 {
+			return nil
+		}
+		overflow, err := polling.NewWatcher(w.pollInterval)
+		if err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.overflow = overflow
+		w.forward(overflow.Events())
+		w.mu.Unlock()
+	}
+	err := w.overflow.UpdateAll(names)
+
+	if len(names) != w.polled {
+		w.polled = len(names)
+		if w.polled > 0 {
+			log.Banner(
+				"Ran out of inotify watches.",
+				fmt.Sprintf("Watching %d directories with inotify and polling %d every %s.", len(w.watched), w.polled, w.pollInterval),
+				"Raise fs.inotify.max_user_watches (sysctl) to watch all of them with inotify.")
+		} else {
+			log.Logf("All %d directories are watched with inotify again.", len(w.watched))
+		}
+	}
+	return err
+}
+
+// backends returns how many names are watched with inotify and how many are
+// polled.
+func (w *realFSNotifyWatcher) backends() (int, int) {
+	return len(w.watched), w.polled
+}
+
+// forward copies events from c to the merged events channel until c closes.
+func (w *realFSNotifyWatcher) forward(c chan common.Event) {
+	w.forwards.Add(1)
+	go func() {
+		defer w.forwards.Done()
+		for e := range c {
+			w.events <- e
+		}
+	}()
+}
+
+// translate copies the inotify events to the merged events channel until the
+// inotify watcher is closed.
+func (w *realFSNotifyWatcher) translate() {
+	defer w.forwards.Done()
+	for e := range w.wrapper.Events() {
+		w.events <- common.Event{Name: e.Name, Op: e.Op}
+	}
+}
+
 // Close implements ibazel/fswatcher/common.Watcher
 func (w *realFSNotifyWatcher) Close() error {
-	return w.wrapper.Close()
+	err := w.wrapper.Close()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.overflow != nil {
+		w.overflow.Close()
+	}
+	go func() {
+		w.forwards.Wait()
+		close(w.events)
+	}()
+	return err
 }
 
 // Events implements ibazel/fswatcher/common.Watcher
 func (w *realFSNotifyWatcher) Events() chan common.Event {
-	return w.wrapper.Events()
+	return w.events
 }
 
-func NewWatcher() (common.Watcher, error) {
+func newRealFSNotifyWatcher(wrapper fsNotifyWatcher, pollInterval time.Duration) *realFSNotifyWatcher {
+	w := &realFSNotifyWatcher{
+		wrapper:      wrapper,
+		pollInterval: pollInterval,
+		events:       make(chan common.Event),
+	}
+	w.forwards.Add(1)
+	go w.translate()
+	return w
+}
+
+// NewWatcher returns an inotify based watcher that polls directories every
+// pollInterval once the inotify watch limit has been reached.
+func NewWatcher(pollInterval time.Duration) (common.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
 	wrapper := &fsNotifyWatcherWrapper{watcher: watcher}
-	return &realFSNotifyWatcher{wrapper: wrapper}, err
+	return newRealFSNotifyWatcher(wrapper, pollInterval), nil
 }
@@ -16,8 +16,12 @@ package fsnotify
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"runtime/debug"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -26,9 +30,16 @@ type mockFSNotifyWatcher struct {
 	recentlyAddedFiles   map[string]struct{}
 	recentlyRemovedFiles map[string]struct{}
 	closed               bool
+	events               chan fsnotify.Event
+
+	// exhausted makes Add fail with ENOSPC for these names.
+	exhausted map[string]struct{}
 }
 
 func (w *mockFSNotifyWatcher) Add(name string) error {
+	if _, ok := w.exhausted[name]; ok {
+		return syscall.ENOSPC
+	}
 	if _, ok := w.recentlyAddedFiles[name]; ok {
 		return errors.New("Already added file " + name)
 	}
@@ -47,10 +58,11 @@ func (w *mockFSNotifyWatcher) Close() error {
 		return errors.New("Already closed")
 	}
 	w.closed = true
+	close(w.events)
 	return nil
 }
 func (w *mockFSNotifyWatcher) Events() chan fsnotify.Event {
-	return nil
+	return w.events
 }
 
 func (w *mockFSNotifyWatcher) Reset() {
@@ -95,9 +107,9 @@ func (w *mockFSNotifyWatcher) assertClosed(t *testing.T, closed bool) {
 }
 
 func newWatcher() (*realFSNotifyWatcher, *mockFSNotifyWatcher) {
-	mock := &mockFSNotifyWatcher{}
+	mock := &mockFSNotifyWatcher{events: make(chan fsnotify.Event)}
 	mock.Reset()
-	watcher := &realFSNotifyWatcher{wrapper: mock}
+	watcher := newRealFSNotifyWatcher(mock, 10*time.Millisecond)
 	return watcher, mock
 }
 
@@ -165,6 +177,55 @@ func TestWatchedFilesState(t *testing.T) {
 	mock.assertClosed(t, true)
 }
 
+func TestWatchLimitFallsBackToPolling(t *testing.T) {
+	watcher, mock := newWatcher()
+	dir := t.TempDir()
+	native := filepath.Join(dir, "native")
+	polled := filepath.Join(dir, "polled")
+	for _, d := range []string{native, polled} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mock.exhausted = map[string]struct{}{polled: {}}
+
+	if err := watcher.UpdateAll([]string{native, polled}); err != nil {
+		t.Fatalf("UpdateAll() = %v, want nil", err)
+	}
+	mock.assertRecentlyAdded(t, []string{native})
+	if n, p := watcher.backends(); n != 1 || p != 1 {
+		t.Errorf("backends() = %d, %d, want 1, 1", n, p)
+	}
+
+	file := filepath.Join(polled, "file")
+	if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-watcher.Events():
+		if e.Name != file {
+			t.Errorf("event for %q, want %q", e.Name, file)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the polled directory")
+	}
+
+	// Once watches are available again the directory moves back to inotify.
+	mock.Reset()
+	mock.exhausted = nil
+	if err := watcher.UpdateAll([]string{native, polled}); err != nil {
+		t.Fatalf("UpdateAll() = %v, want nil", err)
+	}
+	mock.assertRecentlyAdded(t, []string{polled})
+	if n, p := watcher.backends(); n != 2 || p != 0 {
+		t.Errorf("backends() = %d, %d, want 2, 0", n, p)
+	}
+
+	watcher.Close()
+	for range watcher.Events() {
+	}
+}
+
 // Equal tells whether a and b contain the same elements, regardless of order
 func containsAll(a, b []string) (string, bool) {
 OUTER:
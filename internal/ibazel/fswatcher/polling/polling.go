@@ -0,0 +1,201 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package polling implements a watcher that notices changes by periodically
+// comparing the files in the watched directories, for file systems that don't
+// report changes, such as network file systems.
+package polling
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+// fileState is what a change to a file is detected from.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+func stateOf(info os.FileInfo) fileState {
+	return fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+}
+
+type pollingWatcher struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// watched maps every watched name to the state of the files in it. A
+	// watched file is its own only entry.
+	watched map[string]map[string]fileState
+
+	events    chan common.Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ common.Watcher = &pollingWatcher{}
+
+// NewWatcher returns a watcher that looks for changes every interval.
+func NewWatcher(interval time.Duration) (common.Watcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid polling interval %s", interval)
+	}
+	w := &pollingWatcher{
+		interval: interval,
+		watched:  map[string]map[string]fileState{},
+		events:   make(chan common.Event),
+		done:     make(chan struct{}),
+	}
+	go w.poll()
+	return w, nil
+}
+
+// UpdateAll implements ibazel/fswatcher/common.Watcher
+func (w *pollingWatcher) UpdateAll(names []string) error {
+	var errs []string
+	watched := make(map[string]map[string]fileState, len(names))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, name := range names {
+		if files, ok := w.watched[name]; ok {
+			watched[name] = files
+			continue
+		}
+		files, err := scan(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Error watching file %q error: %v", name, err))
+			continue
+		}
+		watched[name] = files
+	}
+	w.watched = watched
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// scan returns the state of the files in the directory name, or of name
+// itself if it is a file.
+func scan(name string) (map[string]fileState, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return map[string]fileState{name: stateOf(info)}, nil
+	}
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		files[filepath.Join(name, entry.Name())] = stateOf(info)
+	}
+	return files, nil
+}
+
+// diff returns the events that turn before into after.
+func diff(before, after map[string]fileState) []common.Event {
+	var events []common.Event
+	for name, state := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			events = append(events, common.Event{Name: name, Op: common.Create})
+		case !state.modTime.Equal(old.modTime) || state.size != old.size:
+			events = append(events, common.Event{Name: name, Op: common.Write})
+		case state.mode != old.mode:
+			events = append(events, common.Event{Name: name, Op: common.Chmod})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			events = append(events, common.Event{Name: name, Op: common.Remove})
+		}
+	}
+	return events
+}
+
+func (w *pollingWatcher) poll() {
+	defer close(w.events)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, e := range w.changes() {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// changes scans the watched names again and returns what changed since the
+// last scan.
+func (w *pollingWatcher) changes() []common.Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var events []common.Event
+	for name, before := range w.watched {
+		after, err := scan(name)
+		if err != nil {
+			// The name itself is gone, which removed everything in it.
+			after = map[string]fileState{}
+		}
+		events = append(events, diff(before, after)...)
+		w.watched[name] = after
+	}
+	return events
+}
+
+// Close implements ibazel/fswatcher/common.Watcher
+func (w *pollingWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
+
+// Events implements ibazel/fswatcher/common.Watcher
+func (w *pollingWatcher) Events() chan common.Event {
+	return w.events
+}
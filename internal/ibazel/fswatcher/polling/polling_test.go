@@ -0,0 +1,89 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package polling
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+func expectEvent(t *testing.T, w common.Watcher, want common.Event) {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		if e != want {
+			t.Errorf("Got event %v, want %v", e, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %v", want)
+	}
+}
+
+func TestPollingWatcher(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	defer w.Close()
+	if err := w.UpdateAll([]string{dir}); err != nil {
+		t.Fatalf("UpdateAll(): %v", err)
+	}
+
+	created := filepath.Join(dir, "created")
+	os.WriteFile(created, nil, 0644)
+	expectEvent(t, w, common.Event{Name: created, Op: common.Create})
+
+	os.WriteFile(existing, []byte("ab"), 0644)
+	expectEvent(t, w, common.Event{Name: existing, Op: common.Write})
+
+	os.Remove(created)
+	expectEvent(t, w, common.Event{Name: created, Op: common.Remove})
+}
+
+func TestPollingWatcher_UpdateAll(t *testing.T) {
+	w, err := NewWatcher(time.Hour)
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	dir := t.TempDir()
+	if err := w.UpdateAll([]string{dir, filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("UpdateAll() should report names that can't be watched")
+	}
+	if got := len(w.(*pollingWatcher).watched); got != 1 {
+		t.Errorf("Watching %d names, want 1", got)
+	}
+
+	w.Close()
+	// The events channel is closed once the watcher has stopped.
+	if _, ok := <-w.Events(); ok {
+		t.Errorf("Got an event after closing the watcher")
+	}
+}
+
+func TestNewWatcher_InvalidInterval(t *testing.T) {
+	if _, err := NewWatcher(0); err == nil {
+		t.Errorf("NewWatcher(0) should fail")
+	}
+}
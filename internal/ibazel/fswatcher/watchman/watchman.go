@@ -0,0 +1,350 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watchman implements a watcher on top of a running watchman daemon,
+// which already watches the repository, instead of adding watches of its own.
+package watchman
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+// Sockname returns the path of the socket of the watchman daemon, starting it
+// if needed.
+func Sockname() (string, error) {
+	if sock := os.Getenv("WATCHMAN_SOCK"); sock != "" {
+		return sock, nil
+	}
+	out, err := exec.Command("watchman", "--output-encoding=json", "--no-pretty", "get-sockname").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the watchman socket: %w", err)
+	}
+	var res struct {
+		Sockname string `json:"sockname"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", fmt.Errorf("unable to find the watchman socket: %w", err)
+	}
+	if res.Error != "" || res.Sockname == "" {
+		return "", fmt.Errorf("unable to find the watchman socket: %s", res.Error)
+	}
+	return res.Sockname, nil
+}
+
+// response is any message from watchman. Unilateral messages carry the changes
+// of a subscription, the others answer a command.
+type response struct {
+	Error         string `json:"error"`
+	Watch         string `json:"watch"`
+	RelativePath  string `json:"relative_path"`
+	Unilateral    bool   `json:"unilateral"`
+	Subscription  string `json:"subscription"`
+	Root          string `json:"root"`
+	FreshInstance bool   `json:"is_fresh_instance"`
+	Files         []file `json:"files"`
+}
+
+type file struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+	New    bool   `json:"new"`
+}
+
+type watchmanWatcher struct {
+	conn net.Conn
+	// name of the subscriptions, unique per watcher within the daemon.
+	name string
+
+	// cmdMu serializes commands, as watchman answers them in order.
+	cmdMu     sync.Mutex
+	responses chan response
+
+	mu      sync.Mutex
+	watched map[string]struct{} // the names given to UpdateAll
+	roots   map[string]struct{} // the watchman roots subscribed to
+	// known are the watchman roots resolved so far, so that names under them
+	// don't need a watch-project round trip. Only used by UpdateAll.
+	known   map[string]struct{}
+	pending []common.Event
+	wake    chan struct{}
+
+	events    chan common.Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ common.Watcher = &watchmanWatcher{}
+
+// NewWatcher connects to the watchman daemon listening on sockname.
+func NewWatcher(sockname string) (common.Watcher, error) {
+	conn, err := net.Dial("unix", sockname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to watchman: %w", err)
+	}
+	w := &watchmanWatcher{
+		conn:      conn,
+		name:      fmt.Sprintf("ibazel-%d-%p", os.Getpid(), conn),
+		responses: make(chan response),
+		watched:   map[string]struct{}{},
+		roots:     map[string]struct{}{},
+		known:     map[string]struct{}{},
+		wake:      make(chan struct{}, 1),
+		events:    make(chan common.Event),
+		done:      make(chan struct{}),
+	}
+	go w.read()
+	go w.deliver()
+	return w, nil
+}
+
+// command sends args to watchman and waits for the answer.
+func (w *watchmanWatcher) command(args ...interface{}) (response, error) {
+	w.cmdMu.Lock()
+	defer w.cmdMu.Unlock()
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return response{}, err
+	}
+	if _, err := w.conn.Write(append(data, '\n')); err != nil {
+		return response{}, err
+	}
+	res, ok := <-w.responses
+	if !ok {
+		return response{}, errors.New("the connection to watchman was closed")
+	}
+	if res.Error != "" {
+		return res, errors.New(res.Error)
+	}
+	return res, nil
+}
+
+// read dispatches the messages from watchman until the connection is closed.
+func (w *watchmanWatcher) read() {
+	defer close(w.responses)
+
+	scanner := bufio.NewScanner(w.conn)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var res response
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			continue
+		}
+		if res.Unilateral || res.Subscription != "" {
+			w.queue(res)
+			continue
+		}
+		select {
+		case w.responses <- res:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// queue turns the files of a subscription message into events. They are
+// delivered by another goroutine, so that answers to commands are never stuck
+// behind events nobody reads yet.
+func (w *watchmanWatcher) queue(res response) {
+	if res.FreshInstance {
+		return
+	}
+	w.mu.Lock()
+	for _, f := range res.Files {
+		name := filepath.Join(res.Root, filepath.FromSlash(f.Name))
+		if !w.isWatched(name) {
+			continue
+		}
+		e := common.Event{Name: name, Op: common.Write}
+		switch {
+		case !f.Exists:
+			e.Op = common.Remove
+		case f.New:
+			e.Op = common.Create
+		}
+		w.pending = append(w.pending, e)
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// isWatched reports whether name or its directory was given to UpdateAll.
+// w.mu must be held.
+func (w *watchmanWatcher) isWatched(name string) bool {
+	if _, ok := w.watched[name]; ok {
+		return true
+	}
+	_, ok := w.watched[filepath.Dir(name)]
+	return ok
+}
+
+func (w *watchmanWatcher) deliver() {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+		w.mu.Lock()
+		pending := w.pending
+		w.pending = nil
+		w.mu.Unlock()
+
+		for _, e := range pending {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// UpdateAll implements ibazel/fswatcher/common.Watcher
+func (w *watchmanWatcher) UpdateAll(names []string) error {
+	var errs []string
+	watched := make(map[string]struct{}, len(names))
+	byRoot := map[string][]string{}
+
+	for _, name := range names {
+		name = filepath.Clean(name)
+		watched[name] = struct{}{}
+		root, ok := w.knownRoot(name)
+		if !ok {
+			res, err := w.command("watch-project", name)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("Error watching file %q error: %v", name, err))
+				continue
+			}
+			root = res.Watch
+			w.known[root] = struct{}{}
+		}
+		byRoot[root] = append(byRoot[root], name)
+	}
+
+	roots := make(map[string]struct{}, len(byRoot))
+	for root := range byRoot {
+		roots[root] = struct{}{}
+	}
+	w.mu.Lock()
+	w.watched = watched
+	previous := w.roots
+	w.roots = roots
+	w.mu.Unlock()
+
+	// Subscribing again under the same name replaces the subscription, which
+	// keeps it limited to the names watched now.
+	for root, names := range byRoot {
+		_, err := w.command("subscribe", root, w.name, map[string]interface{}{
+			"expression":              subscriptionExpression(root, names),
+			"fields":                  []string{"name", "exists", "new"},
+			"empty_on_fresh_instance": true,
+		})
+		if err != nil {
+			// Resolve the root again next time, in case watchman dropped it.
+			delete(w.known, root)
+			errs = append(errs, fmt.Sprintf("Error subscribing to %q error: %v", root, err))
+		}
+	}
+	for root := range previous {
+		if _, ok := roots[root]; ok {
+			continue
+		}
+		if _, err := w.command("unsubscribe", root, w.name); err != nil {
+			errs = append(errs, fmt.Sprintf("Error unsubscribing from %q error: %v", root, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// knownRoot returns the innermost watchman root already resolved that
+// contains name.
+func (w *watchmanWatcher) knownRoot(name string) (string, bool) {
+	best := ""
+	for root := range w.known {
+		if (name == root || strings.HasPrefix(name, root+string(filepath.Separator))) && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best, best != ""
+}
+
+// subscriptionExpression matches the files and symlinks that are one of names
+// or directly in one of them, so that watchman only reports the changes iBazel
+// cares about instead of every change under root.
+func subscriptionExpression(root string, names []string) []interface{} {
+	sort.Strings(names)
+	locations := []interface{}{"anyof"}
+	var wholenames []string
+	for _, name := range names {
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			// The files at the top of the root.
+			locations = append(locations, []interface{}{"match", "*", "wholename", map[string]bool{"includedotfiles": true}})
+			continue
+		}
+		wholenames = append(wholenames, rel)
+		locations = append(locations, []interface{}{"dirname", rel, []interface{}{"depth", "eq", 0}})
+	}
+	if len(wholenames) > 0 {
+		locations = append(locations, []interface{}{"name", wholenames, "wholename"})
+	}
+	return []interface{}{
+		"allof",
+		[]interface{}{"anyof", []string{"type", "f"}, []string{"type", "l"}},
+		locations,
+	}
+}
+
+// Close implements ibazel/fswatcher/common.Watcher
+func (w *watchmanWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		// Closing the connection ends the subscriptions too.
+		err = w.conn.Close()
+	})
+	return err
+}
+
+// Events implements ibazel/fswatcher/common.Watcher
+func (w *watchmanWatcher) Events() chan common.Event {
+	return w.events
+}
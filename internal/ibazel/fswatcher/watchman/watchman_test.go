@@ -0,0 +1,221 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchman
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+)
+
+// fakeWatchman answers the commands of a single client like watchman, with
+// every top level directory being a root.
+type fakeWatchman struct {
+	sockname string
+	commands chan []interface{}
+	conn     chan net.Conn
+}
+
+func newFakeWatchman(t *testing.T) *fakeWatchman {
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir().
+	dir, err := os.MkdirTemp("", "watchman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	f := &fakeWatchman{
+		sockname: filepath.Join(dir, "sock"),
+		commands: make(chan []interface{}, 100),
+		conn:     make(chan net.Conn, 1),
+	}
+	l, err := net.Listen("unix", f.sockname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		f.conn <- conn
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var cmd []interface{}
+			json.Unmarshal(scanner.Bytes(), &cmd)
+			f.commands <- cmd
+
+			var res map[string]interface{}
+			switch cmd[0] {
+			case "watch-project":
+				path := cmd[1].(string)
+				if path == "/missing" {
+					res = map[string]interface{}{"error": "unable to resolve root /missing"}
+				} else {
+					root, rel, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+					res = map[string]interface{}{"watch": "/" + root, "relative_path": rel}
+				}
+			case "subscribe":
+				res = map[string]interface{}{"subscribe": cmd[2], "clock": "c:1"}
+			case "unsubscribe":
+				res = map[string]interface{}{"unsubscribe": cmd[2], "deleted": true}
+			}
+			data, _ := json.Marshal(res)
+			conn.Write(append(data, '\n'))
+		}
+	}()
+	return f
+}
+
+// send sends a message of the subscription to the client.
+func (f *fakeWatchman) send(t *testing.T, conn net.Conn, msg map[string]interface{}) {
+	t.Helper()
+	msg["unilateral"] = true
+	data, _ := json.Marshal(msg)
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *fakeWatchman) expectCommand(t *testing.T, name string, arg string) []interface{} {
+	t.Helper()
+	select {
+	case cmd := <-f.commands:
+		if cmd[0] != name || cmd[1] != arg {
+			t.Errorf("Got command %v, want [%s %s ...]", cmd, name, arg)
+		}
+		return cmd
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s %s", name, arg)
+	}
+	return nil
+}
+
+// expectExpression checks the expression of a subscribe command.
+func expectExpression(t *testing.T, cmd []interface{}, want string) {
+	t.Helper()
+	if len(cmd) < 4 {
+		t.Fatalf("Got command %v without options", cmd)
+	}
+	got, _ := json.Marshal(cmd[3].(map[string]interface{})["expression"])
+	var gotValue, wantValue interface{}
+	json.Unmarshal(got, &gotValue)
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("Got expression %s, want %s", got, want)
+	}
+}
+
+func TestWatchmanWatcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("watchman listens on a named pipe on Windows")
+	}
+	f := newFakeWatchman(t)
+	w, err := NewWatcher(f.sockname)
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	defer w.Close()
+
+	if err := w.UpdateAll([]string{"/ws/a", "/ws/a/link.go", "/missing"}); err == nil {
+		t.Errorf("UpdateAll() should report the directory watchman can't watch")
+	}
+	f.expectCommand(t, "watch-project", "/ws/a")
+	// /ws/a/link.go is under the root /ws/a resolved to.
+	f.expectCommand(t, "watch-project", "/missing")
+	cmd := f.expectCommand(t, "subscribe", "/ws")
+	expectExpression(t, cmd, `["allof",
+		["anyof", ["type", "f"], ["type", "l"]],
+		["anyof",
+			["dirname", "a", ["depth", "eq", 0]],
+			["dirname", "a/link.go", ["depth", "eq", 0]],
+			["name", ["a", "a/link.go"], "wholename"]]]`)
+	conn := <-f.conn
+
+	f.send(t, conn, map[string]interface{}{
+		"subscription": "ibazel",
+		"root":         "/ws",
+		"files": []map[string]interface{}{
+			{"name": "a/created.go", "exists": true, "new": true},
+			{"name": "a/changed.go", "exists": true},
+			{"name": "a/removed.go", "exists": false},
+			// Not in a watched directory.
+			{"name": "a/sub/other.go", "exists": true},
+		},
+	})
+	for _, want := range []common.Event{
+		{Name: "/ws/a/created.go", Op: common.Create},
+		{Name: "/ws/a/changed.go", Op: common.Write},
+		{Name: "/ws/a/removed.go", Op: common.Remove},
+	} {
+		select {
+		case e := <-w.Events():
+			if e != want {
+				t.Errorf("Got event %v, want %v", e, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %v", want)
+		}
+	}
+
+	// Events nobody reads don't hold up commands.
+	f.send(t, conn, map[string]interface{}{
+		"subscription": "ibazel",
+		"root":         "/ws",
+		"files":        []map[string]interface{}{{"name": "a/unread.go", "exists": true}},
+	})
+	// Names under a known root don't need to be resolved again.
+	if err := w.UpdateAll([]string{"/ws", "/ws/b"}); err != nil {
+		t.Errorf("UpdateAll(): %v", err)
+	}
+	cmd = f.expectCommand(t, "subscribe", "/ws")
+	expectExpression(t, cmd, `["allof",
+		["anyof", ["type", "f"], ["type", "l"]],
+		["anyof",
+			["match", "*", "wholename", {"includedotfiles": true}],
+			["dirname", "b", ["depth", "eq", 0]],
+			["name", ["b"], "wholename"]]]`)
+
+	if err := w.UpdateAll([]string{"/other/c"}); err != nil {
+		t.Errorf("UpdateAll(): %v", err)
+	}
+	f.expectCommand(t, "watch-project", "/other/c")
+	f.expectCommand(t, "subscribe", "/other")
+	f.expectCommand(t, "unsubscribe", "/ws")
+
+	w.Close()
+	for range w.Events() {
+		// Drain until the events channel is closed.
+	}
+}
+
+func TestNewWatcher_NoDaemon(t *testing.T) {
+	if _, err := NewWatcher(filepath.Join(t.TempDir(), "sock")); err == nil {
+		t.Errorf("NewWatcher() without a daemon should fail")
+	}
+}
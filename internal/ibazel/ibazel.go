@@ -15,27 +15,40 @@
 package ibazel
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/bazelbuild/bazel-watcher/internal/bazel"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/bazel_config"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/dotenv"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/ignore"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/lifecycle_hooks"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/live_reload"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/output_runner"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/profiler"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/repository_inputs"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/session"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/tags"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/vcs"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/watch_extra"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/watch_filter"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/workspace"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+	"github.com/mattn/go-shellwords"
 )
 
 var osExit = os.Exit
@@ -52,20 +65,38 @@ type State string
 type runnableCommand func(...string) (*bytes.Buffer, error)
 
 const (
-	DEBOUNCE_QUERY State = "DEBOUNCE_QUERY"
-	QUERY          State = "QUERY"
-	WAIT           State = "WAIT"
-	DEBOUNCE_RUN   State = "DEBOUNCE_RUN"
-	RUN            State = "RUN"
-	QUIT           State = "QUIT"
+	DEBOUNCE_QUERY   State = "DEBOUNCE_QUERY"
+	QUERY            State = "QUERY"
+	WAIT             State = "WAIT"
+	DEBOUNCE_RUN     State = "DEBOUNCE_RUN"
+	RUN              State = "RUN"
+	DEBOUNCE_RESTART State = "DEBOUNCE_RESTART"
+	RESTART          State = "RESTART"
+	VCS_WAIT         State = "VCS_WAIT"
+	QUIT             State = "QUIT"
 )
 
+// vcsPollInterval is how often iBazel checks whether a git operation that holds
+// iterations has finished.
+const vcsPollInterval = 250 * time.Millisecond
+
+// vcsWaitWarning is how long iBazel holds iterations for a git operation
+// before saying which file it is waiting on.
+const vcsWaitWarning = 30 * time.Second
+
+// vcsStaleLock is how long a git lock file has to stay untouched before it is
+// assumed to be left behind by a git command that was killed, and ignored.
+const vcsStaleLock = 5 * time.Minute
+
 const sourceQuery = "kind('source file', deps(set(%s)))"
 const targetQuery = "deps(set(%s))"
 const buildQuery = "buildfiles(set(%s))"
 
 type IBazel struct {
 	debounceDuration time.Duration
+	// runDebounce is the ibazel_debounce tag of the run target, which
+	// overrides debounceDuration while that target is run.
+	runDebounce time.Duration
 
 	cmd         command.Command
 	args        []string
@@ -79,11 +110,93 @@ type IBazel struct {
 
 	buildFileWatcher  common.Watcher
 	sourceFileWatcher common.Watcher
+	envFileWatcher    common.Watcher
+	extraFileWatcher  common.Watcher
+	// configFileWatcher watches the .bazelrc chain and .bazelversion, which
+	// change how every target is built.
+	configFileWatcher common.Watcher
+
+	// bazelVersion is the Bazel version pinned in the workspace when it was
+	// last queried, to warn when it changes.
+	bazelVersion     string
+	bazelVersionRead bool
+
+	// envFiles are dotenv files whose variables are passed to the run target.
+	// Changing them restarts the target without rebuilding it.
+	envFiles []string
+
+	// watchExtra are the rules given with --watch_extra, and extraRules all the
+	// rules in effect, including the ones of the workspace's config file.
+	watchExtra []watch_extra.Rule
+	extraRules []watch_extra.Rule
+
+	// ignorePatterns are given with --ignore, and ignore combines them with
+	// the workspace's ignore file, which is watched like a BUILD file.
+	ignorePatterns []string
+	ignore         *ignore.Matcher
+	ignoreFile     string
+
+	// gitDir is the git directory of the workspace, watched for operations
+	// such as checkouts that change many files at once.
+	gitDir string
+	// vcsWaitStart is when iterations started being held for a git operation.
+	vcsWaitStart time.Time
+	// vcsWaitWarned is set once iBazel said which file it is waiting on.
+	vcsWaitWarned bool
+	// staleVCSLock is the last lock file ignored as stale, so that it is only
+	// reported once.
+	staleVCSLock vcs.Op
+	// vcsWaitTimeout is how long iterations are held for a git merge, rebase,
+	// cherry-pick or revert, or 0 to hold them until it finishes.
+	vcsWaitTimeout time.Duration
+	// vcsTimedOut is the file of the git operation that held iterations for
+	// longer than vcsWaitTimeout, which doesn't hold them anymore.
+	vcsTimedOut string
 
 	filesWatched map[common.Watcher]map[string]struct{} // Inner map is a surrogate for a set
+	// filesMissing holds the files to watch that didn't exist when queried.
+	// Their parent directories are watched, so they move to filesWatched once
+	// created.
+	filesMissing map[common.Watcher]map[string]struct{}
+	// repositoryInputs holds the files external repositories are fetched from.
+	// With refetch, a change to one of them sets refetchPending to have the
+	// repositories fetched again before the next query.
+	repositoryInputs map[string]struct{}
+	refetch          bool
+	refetchPending   bool
+	// repositoryScanLogged is set once iBazel logged that it scans the module
+	// files for repository inputs.
+	repositoryScanLogged bool
+
+	// symlinks holds the symbolic links in the workspace followed to reach the
+	// watched files. The build graph is queried again when one changes.
+	symlinks map[common.Watcher]map[string]struct{}
 
 	lifecycleListeners []Lifecycle
 
+	// iterationCount and changedFiles describe the current iteration to the
+	// run target through its environment.
+	iterationCount int
+	changedFiles   map[string]struct{}
+	// changedFilesLists are the IBAZEL_CHANGED_FILES_LIST files written for
+	// processes that may still be running, oldest first. Every process gets a
+	// file of its own, so that a new one doesn't overwrite the file an old one
+	// is still reading with --run_overlap.
+	changedFilesMu    sync.Mutex
+	changedFilesLists []string
+
+	// session records the process of the run target, so that a later iBazel
+	// can clean up after this one if it gets killed.
+	session               *session.Session
+	orphanedProcessAction string
+
+	// watchDiscovery is how the source files to watch are found: "query" for
+	// the source files the targets depend on, or "aquery" for the inputs of
+	// their actions in the current configuration.
+	watchDiscovery string
+	// watchFilter restricts the watched files to some packages.
+	watchFilter *watch_filter.Filter
+
 	state State
 }
 
@@ -96,10 +209,14 @@ func New(version string) (*IBazel, error) {
 
 	i.debounceDuration = 100 * time.Millisecond
 	i.filesWatched = map[common.Watcher]map[string]struct{}{}
+	i.filesMissing = map[common.Watcher]map[string]struct{}{}
+	i.symlinks = map[common.Watcher]map[string]struct{}{}
 	i.workspaceFinder = &workspace.MainWorkspace{}
+	i.orphanedProcessAction = "ask"
+	i.watchDiscovery = "query"
 
 	i.sigs = make(chan os.Signal, 1)
-	signal.Notify(i.sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(i.sigs, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, forwardedSignals...)...)
 
 	liveReload := live_reload.New()
 	profiler := profiler.New(version)
@@ -130,9 +247,18 @@ func New(version string) (*IBazel, error) {
 }
 
 func (i *IBazel) handleSignals() {
-	// Got an OS signal (SIGINT, SIGTERM, SIGHUP).
+	// Got an OS signal (SIGINT, SIGTERM, SIGHUP or one of forwardedSignals).
 	sig := <-i.sigs
 
+	for _, forwarded := range forwardedSignals {
+		if sig == forwarded {
+			if i.cmd != nil && i.cmd.IsSubprocessRunning() {
+				i.cmd.Signal(sig.(syscall.Signal))
+			}
+			return
+		}
+	}
+
 	if i.cmd == nil || !i.cmd.IsSubprocessRunning() {
 		osExit(3)
 		return
@@ -186,9 +312,119 @@ func (i *IBazel) SetDebounceDuration(debounceDuration time.Duration) {
 	i.debounceDuration = debounceDuration
 }
 
+// debounce returns how long to wait for changes to settle before acting on
+// them.
+func (i *IBazel) debounce() time.Duration {
+	if i.runDebounce != 0 {
+		return i.runDebounce
+	}
+	return i.debounceDuration
+}
+
+// SetEnvFiles sets the dotenv files to load the environment of the run target
+// from. Later files override earlier ones.
+func (i *IBazel) SetEnvFiles(paths []string) {
+	i.envFiles = nil
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		i.envFiles = append(i.envFiles, path)
+	}
+}
+
+// SetWatchExtra sets extra files to watch from values of --watch_extra.
+func (i *IBazel) SetWatchExtra(values []string) error {
+	i.watchExtra = nil
+	for _, value := range values {
+		rule, err := watch_extra.ParseFlag(value)
+		if err != nil {
+			return err
+		}
+		i.watchExtra = append(i.watchExtra, rule)
+	}
+	return nil
+}
+
+// SetVCSWaitTimeout sets how long iterations are held for a git merge, rebase,
+// cherry-pick or revert in progress. 0 holds them until it finishes.
+func (i *IBazel) SetVCSWaitTimeout(timeout time.Duration) {
+	i.vcsWaitTimeout = timeout
+}
+
+// SetRefetch sets whether external repositories are fetched again when a file
+// they are fetched from, such as MODULE.bazel or a lockfile, changes.
+func (i *IBazel) SetRefetch(refetch bool) {
+	i.refetch = refetch
+}
+
+// SetIgnorePatterns sets gitignore-style patterns of files not to watch, in
+// addition to the ones in the workspace's ignore file.
+func (i *IBazel) SetIgnorePatterns(patterns []string) {
+	i.ignorePatterns = patterns
+}
+
+// SetOrphanedProcessAction sets what happens to the process of the run target
+// left behind by an iBazel that was killed: "ask", "kill" or "ignore".
+func (i *IBazel) SetOrphanedProcessAction(action string) {
+	switch action {
+	case "ask", "kill", "ignore":
+		i.orphanedProcessAction = action
+	default:
+		log.Errorf("Unknown orphaned process action %q, using %q", action, i.orphanedProcessAction)
+	}
+}
+
+// SetWatchDiscovery sets how the source files to watch are found, "query" or
+// "aquery".
+func (i *IBazel) SetWatchDiscovery(mode string) {
+	switch mode {
+	case "query", "aquery":
+		i.watchDiscovery = mode
+	default:
+		log.Errorf("Unknown watch discovery %q, using %q", mode, i.watchDiscovery)
+	}
+}
+
+// SetWatchFilter restricts the watched files to the packages matched by the
+// label patterns in only, if any, and not matched by the ones in exclude. Each
+// pattern may be a comma separated list.
+func (i *IBazel) SetWatchFilter(only, exclude []string) error {
+	filter, err := watch_filter.New(splitPatterns(only), splitPatterns(exclude))
+	if err != nil {
+		return err
+	}
+	i.watchFilter = filter
+	return nil
+}
+
+func splitPatterns(patterns []string) []string {
+	var split []string
+	for _, p := range patterns {
+		for _, pattern := range strings.Split(p, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				split = append(split, pattern)
+			}
+		}
+	}
+	return split
+}
+
 func (i *IBazel) Cleanup() {
+	if i.session != nil {
+		i.session.Clear()
+	}
+	i.changedFilesMu.Lock()
+	for _, list := range i.changedFilesLists {
+		os.Remove(list)
+	}
+	i.changedFilesLists = nil
+	i.changedFilesMu.Unlock()
 	i.buildFileWatcher.Close()
 	i.sourceFileWatcher.Close()
+	i.envFileWatcher.Close()
+	i.extraFileWatcher.Close()
+	i.configFileWatcher.Close()
 	for _, l := range i.lifecycleListeners {
 		l.Cleanup()
 	}
@@ -213,6 +449,10 @@ func (i *IBazel) targetDecider(target string, rule *blaze_query.Rule) {
 }
 
 func (i *IBazel) changeDetected(targets []string, changeType string, change string) {
+	if i.changedFiles == nil {
+		i.changedFiles = map[string]struct{}{}
+	}
+	i.changedFiles[change] = struct{}{}
 	for _, l := range i.lifecycleListeners {
 		l.ChangeDetected(targets, changeType, change)
 	}
@@ -230,6 +470,12 @@ func (i *IBazel) afterCommand(targets []string, command string, success bool, ou
 	}
 }
 
+func (i *IBazel) processExited(target string, exitCode int, signal string, duration time.Duration, requested bool) {
+	for _, l := range i.lifecycleListeners {
+		l.ProcessExited(target, exitCode, signal, duration, requested)
+	}
+}
+
 func (i *IBazel) setup() error {
 	var err error
 
@@ -245,6 +491,21 @@ func (i *IBazel) setup() error {
 		return err
 	}
 
+	i.envFileWatcher, err = fswatcher.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	i.extraFileWatcher, err = fswatcher.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	i.configFileWatcher, err = fswatcher.NewWatcher()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -278,45 +539,148 @@ func (i *IBazel) loop(command string, commandToRun runnableCommand, targets []st
 	}
 }
 
-// fsnotify also triggers for file stat and read operations. Explicitly filter the modifying events
-// to avoid triggering builds on file accesses (e.g. due to your IDE checking modified status).
-const modifyingEvents = common.Write | common.Create | common.Rename | common.Remove
+// watchedChange returns the name of the file watched by watcher that e changed,
+// checking both ends of a rename. Watchers also report metadata changes, which
+// are filtered out to avoid triggering builds on file accesses (e.g. due to
+// your IDE checking modified status). A missing file that e creates is
+// watched from now on.
+func (i *IBazel) watchedChange(watcher common.Watcher, e common.Event) (string, bool) {
+	if !e.ContentChanged() {
+		return "", false
+	}
+	for _, name := range e.Names() {
+		if _, ok := i.filesWatched[watcher][name]; ok {
+			return name, true
+		}
+	}
+	if _, ok := i.filesMissing[watcher][e.Name]; ok && e.Has(common.Create|common.Rename) {
+		delete(i.filesMissing[watcher], e.Name)
+		if i.filesWatched[watcher] == nil {
+			i.filesWatched[watcher] = map[string]struct{}{}
+		}
+		i.filesWatched[watcher][e.Name] = struct{}{}
+		return e.Name, true
+	}
+	return "", false
+}
+
+// symlinkChange returns the symbolic link followed to a file watched by
+// watcher that e changed.
+func (i *IBazel) symlinkChange(watcher common.Watcher, e common.Event) (string, bool) {
+	if !e.ContentChanged() {
+		return "", false
+	}
+	for _, name := range e.Names() {
+		if _, ok := i.symlinks[watcher][name]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// extraChange returns the rule matching the file e changed, if any.
+func (i *IBazel) extraChange(e common.Event) (watch_extra.Rule, string, bool) {
+	if !e.ContentChanged() {
+		return watch_extra.Rule{}, "", false
+	}
+	for _, name := range e.Names() {
+		if rule, ok := watch_extra.Match(i.extraRules, name); ok && !i.ignore.Ignored(name) {
+			return rule, name, true
+		}
+	}
+	return watch_extra.Rule{}, "", false
+}
 
 func (i *IBazel) iteration(command string, commandToRun runnableCommand, targets []string, joinedTargets string) {
 	switch i.state {
 	case WAIT:
 		select {
 		case e := <-i.sourceFileWatcher.Events():
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("Changed: %q. Rebuilding...", e.Name)
-				i.changeDetected(targets, "source", e.Name)
+			if name, ok := i.symlinkChange(i.sourceFileWatcher, e); ok {
+				log.Logf("Symlink changed: %q. Requerying...", name)
+				i.changeDetected(targets, "source", name)
+				i.state = DEBOUNCE_QUERY
+			} else if name, ok := i.watchedChange(i.sourceFileWatcher, e); ok {
+				log.Logf("Changed: %q. Rebuilding...", name)
+				i.changeDetected(targets, "source", name)
 				i.state = DEBOUNCE_RUN
 			}
 		case e := <-i.buildFileWatcher.Events():
-			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("Build graph changed: %q. Requerying...", e.Name)
-				i.changeDetected(targets, "graph", e.Name)
+			if name, ok := i.symlinkChange(i.buildFileWatcher, e); ok {
+				log.Logf("Symlink changed: %q. Requerying...", name)
+				i.changeDetected(targets, "graph", name)
 				i.state = DEBOUNCE_QUERY
+			} else if name, ok := i.watchedChange(i.buildFileWatcher, e); ok {
+				if i.repositoryInputChanged(name) {
+					log.Logf("External dependencies changed: %q. Requerying...", name)
+				} else {
+					log.Logf("Build graph changed: %q. Requerying...", name)
+				}
+				i.changeDetected(targets, "graph", name)
+				i.state = DEBOUNCE_QUERY
+			}
+		case e := <-i.envFileWatcher.Events():
+			if name, ok := i.watchedChange(i.envFileWatcher, e); ok {
+				log.Logf("Environment changed: %q. Restarting...", name)
+				i.changeDetected(targets, "env", name)
+				i.state = DEBOUNCE_RESTART
+			}
+		case e := <-i.configFileWatcher.Events():
+			if name, ok := i.watchedChange(i.configFileWatcher, e); ok {
+				log.Logf("Bazel configuration changed: %q. Requerying...", name)
+				i.changeDetected(targets, "config", name)
+				i.state = DEBOUNCE_QUERY
+			}
+		case e := <-i.extraFileWatcher.Events():
+			if rule, name, ok := i.extraChange(e); ok {
+				i.changeDetected(targets, "extra", name)
+				i.extraFileChanged(rule, name)
 			}
 		}
 	case DEBOUNCE_QUERY:
 		select {
 		case e := <-i.buildFileWatcher.Events():
-			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "graph", e.Name)
+			if name, ok := i.watchedChange(i.buildFileWatcher, e); ok {
+				i.repositoryInputChanged(name)
+				i.changeDetected(targets, "graph", name)
 			}
 			i.state = DEBOUNCE_QUERY
-		case <-time.After(i.debounceDuration):
-			i.state = QUERY
+		case e := <-i.configFileWatcher.Events():
+			if name, ok := i.watchedChange(i.configFileWatcher, e); ok {
+				i.changeDetected(targets, "config", name)
+			}
+			i.state = DEBOUNCE_QUERY
+		case <-time.After(i.debounce()):
+			if !i.holdForVCS() {
+				i.state = QUERY
+			}
 		}
 	case QUERY:
 		// Query for which files to watch.
 		log.Logf("Querying for files to watch...")
+		i.loadIgnore()
+		var repositoryInputs []string
+		if workspacePath, err := i.workspaceFinder.FindWorkspace(); err == nil {
+			i.gitDir = vcs.FindGitDir(workspacePath)
+			repositoryInputs = i.findRepositoryInputs(workspacePath)
+			i.watchConfigFiles(workspacePath)
+		}
+		i.setRepositoryInputs(repositoryInputs)
+		if i.refetchPending {
+			i.refetchRepositories(targets)
+		}
 
 		toWatchBuildFiles, err := i.queryForBuildFiles(joinedTargets)
 		if err != nil {
 			log.Errorf("Error querying for build files: %v", err)
 		} else {
+			if i.ignoreFile != "" {
+				// Requery when the ignore file changes to apply it.
+				toWatchBuildFiles = append(toWatchBuildFiles, i.ignoreFile)
+			}
+			// Requery when a file external repositories are fetched from changes,
+			// as Bazel doesn't report them as build files.
+			toWatchBuildFiles = append(toWatchBuildFiles, repositoryInputs...)
 			i.watchFiles(toWatchBuildFiles, i.buildFileWatcher)
 		}
 
@@ -327,24 +691,330 @@ func (i *IBazel) iteration(command string, commandToRun runnableCommand, targets
 			i.watchFiles(toWatchSourceFiles, i.sourceFileWatcher)
 		}
 
+		if len(i.envFiles) > 0 && command == "run" {
+			i.watchNamedFiles(i.envFiles, i.envFileWatcher)
+		}
+		i.watchExtraFiles()
+
 		i.state = RUN
 	case DEBOUNCE_RUN:
 		select {
 		case e := <-i.sourceFileWatcher.Events():
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "source", e.Name)
+			i.state = DEBOUNCE_RUN
+			if name, ok := i.symlinkChange(i.sourceFileWatcher, e); ok {
+				i.changeDetected(targets, "source", name)
+				i.state = DEBOUNCE_QUERY
+			} else if name, ok := i.watchedChange(i.sourceFileWatcher, e); ok {
+				i.changeDetected(targets, "source", name)
+			}
+		case e := <-i.envFileWatcher.Events():
+			// The rebuilt target picks up the new environment anyway.
+			if name, ok := i.watchedChange(i.envFileWatcher, e); ok {
+				i.changeDetected(targets, "env", name)
 			}
 			i.state = DEBOUNCE_RUN
-		case <-time.After(i.debounceDuration):
-			i.state = RUN
+		case <-time.After(i.debounce()):
+			if !i.holdForVCS() {
+				i.state = RUN
+			}
 		}
 	case RUN:
 		log.Logf("%s %s", strings.Title(verb(command)), joinedTargets)
+		i.iterationCount++
 		i.beforeCommand(targets, command)
 		outputBuffer, err := commandToRun(targets...)
+		i.changedFiles = nil
 		i.interruptCount = 0
 		i.afterCommand(targets, command, err == nil, outputBuffer)
 		i.state = WAIT
+	case DEBOUNCE_RESTART:
+		select {
+		case e := <-i.envFileWatcher.Events():
+			if name, ok := i.watchedChange(i.envFileWatcher, e); ok {
+				i.changeDetected(targets, "env", name)
+			}
+			i.state = DEBOUNCE_RESTART
+		case e := <-i.sourceFileWatcher.Events():
+			// A source change needs a rebuild, which restarts the target too.
+			if name, ok := i.watchedChange(i.sourceFileWatcher, e); ok {
+				i.changeDetected(targets, "source", name)
+				i.state = DEBOUNCE_RUN
+			}
+		case <-time.After(i.debounce()):
+			if !i.holdForVCS() {
+				i.state = RESTART
+			}
+		}
+	case RESTART:
+		i.state = WAIT
+		if i.cmd == nil {
+			// Without a run target to restart, run the command again.
+			i.state = RUN
+			break
+		}
+		log.Logf("Restarting %s", joinedTargets)
+		i.cmd.SetEnv(i.runEnv(targets[0]))
+		i.cmd.SetIteration(i.iterationCount)
+		if err := i.cmd.Restart(); err != nil {
+			log.Logf("Nothing to restart, rebuilding instead")
+			i.state = RUN
+			break
+		}
+		i.changedFiles = nil
+	case VCS_WAIT:
+		op, ok := i.vcsOperation()
+		if !ok {
+			log.Logf("Git operation finished. Requerying...")
+			i.state = DEBOUNCE_QUERY
+			break
+		}
+		if !op.Lock && i.vcsWaitTimeout > 0 && time.Since(i.vcsWaitStart) > i.vcsWaitTimeout {
+			log.Errorf("Git %s still in progress after %s. Not waiting for it anymore.", op.Name, i.vcsWaitTimeout)
+			i.vcsTimedOut = op.Path
+			i.state = DEBOUNCE_QUERY
+			break
+		}
+		if !i.vcsWaitWarned && time.Since(i.vcsWaitStart) > vcsWaitWarning {
+			if op.Lock {
+				log.Logf("Still waiting on %s; remove it if no git command is running", op.Path)
+			} else {
+				log.Logf("Still waiting for git %s to finish. Builds resume after git %s --continue or git %s --abort. Use --vcs_wait_timeout to stop waiting after a while.", op.Name, op.Name, op.Name)
+			}
+			i.vcsWaitWarned = true
+		}
+		// Keep track of the changes made in the meantime. Everything is
+		// requeried and rebuilt once the operation finishes.
+		select {
+		case e := <-i.sourceFileWatcher.Events():
+			if name, ok := i.watchedChange(i.sourceFileWatcher, e); ok {
+				i.changeDetected(targets, "source", name)
+			}
+		case e := <-i.buildFileWatcher.Events():
+			if name, ok := i.watchedChange(i.buildFileWatcher, e); ok {
+				i.repositoryInputChanged(name)
+				i.changeDetected(targets, "graph", name)
+			}
+		case e := <-i.configFileWatcher.Events():
+			if name, ok := i.watchedChange(i.configFileWatcher, e); ok {
+				i.changeDetected(targets, "config", name)
+			}
+		case <-i.envFileWatcher.Events():
+		case <-i.extraFileWatcher.Events():
+		case <-time.After(vcsPollInterval):
+		}
+	}
+}
+
+// watchConfigFiles watches the Bazel configuration of the workspace, and warns
+// when the pinned Bazel version changed since the last query.
+func (i *IBazel) watchConfigFiles(workspacePath string) {
+	version := bazel_config.Version(workspacePath)
+	if i.bazelVersionRead && version != i.bazelVersion {
+		log.Errorf("Bazel version changed from %s to %s. The Bazel server will restart, so this build will be slower.", describeVersion(i.bazelVersion), describeVersion(version))
+	}
+	i.bazelVersion = version
+	i.bazelVersionRead = true
+
+	if files := bazel_config.Files(workspacePath, i.startupArgs); len(files) > 0 {
+		i.watchNamedFiles(files, i.configFileWatcher)
+	}
+}
+
+func describeVersion(version string) string {
+	if version == "" {
+		return "the default"
+	}
+	return version
+}
+
+// findRepositoryInputs returns the files external repositories are fetched
+// from: the module and WORKSPACE files, and the files of the main repository
+// named in the attributes of repositories and the tags of module extensions.
+// When Bazel can't print those, they are found by scanning the module and
+// WORKSPACE files instead.
+func (i *IBazel) findRepositoryInputs(workspacePath string) []string {
+	labels, err := i.repositoryLabels(workspacePath)
+	if err != nil {
+		if !i.repositoryScanLogged {
+			log.Logf("Finding repository inputs in the module and WORKSPACE files, as Bazel can't list them: %v", err)
+			i.repositoryScanLogged = true
+		}
+		return repository_inputs.Find(workspacePath)
+	}
+	return repository_inputs.Files(workspacePath, labels)
+}
+
+// repositoryLabels returns the main repository labels in the definitions of
+// the repositories visible from the main repository, in the tags of the module
+// extensions and in the attributes of the WORKSPACE repositories.
+func (i *IBazel) repositoryLabels(workspacePath string) ([]string, error) {
+	var labels []string
+	repos, err := i.showRootRepos()
+	if err != nil {
+		return nil, err
+	}
+	labels = append(labels, repository_inputs.Labels(repos)...)
+
+	b := i.newBazel()
+	graph, err := b.Mod("graph", "--output=json", "--extension_info=usages")
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := repository_inputs.ExtensionIDs(graph.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the module graph: %w", err)
+	}
+	if len(extensions) > 0 {
+		usages, err := b.Mod(append([]string{"show_extension"}, extensions...)...)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, repository_inputs.Labels(usages.String())...)
+	}
+
+	if hasWorkspaceFile(workspacePath) {
+		res, err := b.Query("//external:*")
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range res.Target {
+			for _, attr := range target.GetRule().GetAttribute() {
+				labels = append(labels, attr.GetStringValue())
+				labels = append(labels, attr.GetStringListValue()...)
+			}
+		}
+	}
+	return labels, nil
+}
+
+// showRootRepos returns the definitions of the repositories visible from the
+// main repository, as printed by bazel mod show_repo.
+func (i *IBazel) showRootRepos() (string, error) {
+	mapping, _, err := i.newBazel().DumpRepoMapping("")
+	if err != nil {
+		return "", err
+	}
+	set := map[string]struct{}{}
+	for _, canonical := range mapping {
+		// The main repository is the empty canonical name.
+		if canonical != "" {
+			set["@@"+canonical] = struct{}{}
+		}
+	}
+	if len(set) == 0 {
+		return "", nil
+	}
+	repos := keys(set)
+	sort.Strings(repos)
+	out, err := i.newBazel().Mod(append([]string{"show_repo"}, repos...)...)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// hasWorkspaceFile reports whether the workspace declares repositories in a
+// WORKSPACE file.
+func hasWorkspaceFile(workspacePath string) bool {
+	for _, name := range []string{"WORKSPACE", "WORKSPACE.bazel"} {
+		if _, err := os.Stat(filepath.Join(workspacePath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setRepositoryInputs sets the files external repositories are fetched from,
+// resolved like the watched files.
+func (i *IBazel) setRepositoryInputs(files []string) {
+	i.repositoryInputs = map[string]struct{}{}
+	for _, file := range files {
+		if path, err := filepath.EvalSymlinks(file); err == nil {
+			i.repositoryInputs[path] = struct{}{}
+		}
+	}
+}
+
+// repositoryInputChanged reports whether name is a file external repositories
+// are fetched from, and if so has them fetched again before the next query
+// when asked to.
+func (i *IBazel) repositoryInputChanged(name string) bool {
+	if _, ok := i.repositoryInputs[name]; !ok {
+		return false
+	}
+	if i.refetch {
+		i.refetchPending = true
+	}
+	return true
+}
+
+// refetchRepositories fetches the external repositories of targets again.
+func (i *IBazel) refetchRepositories(targets []string) {
+	i.refetchPending = false
+	log.Logf("Refetching external repositories...")
+	b := i.newBazel()
+	if _, err := b.Fetch(append([]string{"--force"}, targets...)...); err != nil {
+		log.Errorf("Error refetching external repositories: %v", err)
+	}
+}
+
+// vcsOperation returns the git operation in progress in the workspace, if any.
+// A lock file that hasn't changed for vcsStaleLock is assumed to be left
+// behind by a git command that was killed and doesn't count, and neither does
+// an operation that outlasted vcsWaitTimeout.
+func (i *IBazel) vcsOperation() (vcs.Op, bool) {
+	op, ok := vcs.Current(i.gitDir)
+	if !ok {
+		i.vcsTimedOut = ""
+		return op, false
+	}
+	if op.Path == i.vcsTimedOut {
+		return op, false
+	}
+	if !op.Lock || time.Since(op.ModTime) < vcsStaleLock {
+		return op, true
+	}
+	if i.staleVCSLock.Path != op.Path || !i.staleVCSLock.ModTime.Equal(op.ModTime) {
+		log.Errorf("Ignoring %s, which hasn't changed for %s; remove it if no git command is running", op.Path, time.Since(op.ModTime).Round(time.Second))
+		i.staleVCSLock = op
+	}
+	return op, false
+}
+
+// holdForVCS holds iterations while a git operation such as a checkout is in
+// progress, as the working tree is only half written until it finishes.
+func (i *IBazel) holdForVCS() bool {
+	op, ok := i.vcsOperation()
+	if !ok {
+		return false
+	}
+	log.Logf("Waiting for git %s to finish...", op.Name)
+	i.state = VCS_WAIT
+	i.vcsWaitStart = time.Now()
+	i.vcsWaitWarned = false
+	return true
+}
+
+// extraFileChanged reacts to a change to a file matched by rule.
+func (i *IBazel) extraFileChanged(rule watch_extra.Rule, name string) {
+	switch rule.Action {
+	case watch_extra.Restart:
+		log.Logf("Changed: %q. Restarting...", name)
+		i.state = DEBOUNCE_RESTART
+	case watch_extra.Rebuild:
+		log.Logf("Changed: %q. Rebuilding...", name)
+		i.state = DEBOUNCE_RUN
+	case watch_extra.Requery:
+		log.Logf("Changed: %q. Requerying...", name)
+		i.state = DEBOUNCE_QUERY
+	case watch_extra.Hook:
+		log.Logf("Changed: %q. Running hook...", name)
+		args, err := shellwords.Parse(rule.Command)
+		if err != nil || len(args) == 0 {
+			log.Errorf("Invalid hook command %q: %v", rule.Command, err)
+			return
+		}
+		i.workspaceFinder.ExecuteCommand(args[0], args[1:])
 	}
 }
 
@@ -409,15 +1079,6 @@ func (i *IBazel) test(targets ...string) (*bytes.Buffer, error) {
 	return outputBuffer, err
 }
 
-func contains(l []string, e string) bool {
-	for _, i := range l {
-		if i == e {
-			return true
-		}
-	}
-	return false
-}
-
 func (i *IBazel) setupRun(target string) command.Command {
 	rule, err := i.queryRule(target)
 	if err != nil {
@@ -426,21 +1087,40 @@ func (i *IBazel) setupRun(target string) command.Command {
 
 	i.targetDecider(target, rule)
 
-	commandNotify := false
-	for _, attr := range rule.Attribute {
-		if *attr.Name == "tags" && *attr.Type == blaze_query.Attribute_STRING_LIST {
-			if contains(attr.StringListValue, "ibazel_notify_changes") {
-				commandNotify = true
-			}
-		}
+	config, errs := tags.Parse(rule)
+	for _, err := range errs {
+		log.Errorf("%s: %v", target, err)
+	}
+	i.runDebounce = config.Debounce
+	opts := command.Options{
+		RestartSignal: config.RestartSignal,
+		GracePeriod:   config.GracePeriod,
+		NoRestart:     config.NoRestart,
+		ReadyPort:     config.ReadyPort,
 	}
 
-	if commandNotify {
+	var cmd command.Command
+	if config.NotifyChanges {
 		log.Logf("Launching with notifications")
-		return commandNotifyCommand(i.startupArgs, i.bazelArgs, target, i.args)
+		cmd = commandNotifyCommand(i.startupArgs, i.bazelArgs, target, i.args, opts)
 	} else {
-		return commandDefaultCommand(i.startupArgs, i.bazelArgs, target, i.args)
+		cmd = commandDefaultCommand(i.startupArgs, i.bazelArgs, target, i.args, opts)
+	}
+	if workspacePath, err := i.workspaceFinder.FindWorkspace(); err == nil {
+		if i.session, err = session.New(workspacePath, target); err != nil {
+			log.Errorf("Not recording the session of %s: %v", target, err)
+		}
 	}
+	cmd.SetStartHandler(func(pid int) {
+		if i.session != nil {
+			i.session.Record(pid)
+		}
+	})
+	cmd.SetExitHandler(func(status command.ExitStatus) {
+		i.removeOldChangedFilesLists()
+		i.processExited(target, status.ExitCode, status.Signal, status.Duration, status.Requested)
+	})
+	return cmd
 }
 
 func (i *IBazel) run(targets ...string) (*bytes.Buffer, error) {
@@ -448,6 +1128,9 @@ func (i *IBazel) run(targets ...string) (*bytes.Buffer, error) {
 		// If the command is empty, we are in our first pass through the state
 		// machine and we need to make a command object.
 		i.cmd = i.setupRun(targets[0])
+		i.handleOrphan()
+		i.cmd.SetEnv(i.runEnv(targets[0]))
+		i.cmd.SetIteration(i.iterationCount)
 		outputBuffer, err := i.cmd.Start()
 		if err != nil {
 			log.Errorf("Run start failed %v", err)
@@ -456,10 +1139,131 @@ func (i *IBazel) run(targets ...string) (*bytes.Buffer, error) {
 	}
 
 	log.Logf("Notifying of changes")
+	i.cmd.SetEnv(i.runEnv(targets[0]))
+	i.cmd.SetIteration(i.iterationCount)
 	outputBuffer := i.cmd.NotifyOfChanges()
 	return outputBuffer, nil
 }
 
+// maxChangedFilesEnv is the longest list of changed files passed in
+// IBAZEL_CHANGED_FILES. Longer lists are only available through
+// IBAZEL_CHANGED_FILES_LIST.
+const maxChangedFilesEnv = 8192
+
+// runEnv returns the environment variables that tell the run target about the
+// current iteration.
+func (i *IBazel) runEnv(target string) []string {
+	changed := keys(i.changedFiles)
+	sort.Strings(changed)
+
+	var env []string
+	if len(i.envFiles) > 0 {
+		if vars, err := dotenv.Load(i.envFiles...); err != nil {
+			log.Errorf("Error loading environment files: %v", err)
+		} else {
+			env = vars.Environ()
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("IBAZEL_ITERATION=%d", i.iterationCount),
+		"IBAZEL_TARGET="+target,
+		"IBAZEL_COMMAND=run",
+	)
+	if joined := strings.Join(changed, string(os.PathListSeparator)); len(joined) <= maxChangedFilesEnv {
+		env = append(env, "IBAZEL_CHANGED_FILES="+joined)
+	}
+	if list, err := i.writeChangedFilesList(changed); err != nil {
+		log.Errorf("Unable to write the list of changed files: %v", err)
+	} else {
+		env = append(env, "IBAZEL_CHANGED_FILES_LIST="+list)
+	}
+	return env
+}
+
+// writeChangedFilesList writes one changed file per line to a new temporary
+// file named after the iteration, and returns its path.
+func (i *IBazel) writeChangedFilesList(changed []string) (string, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("ibazel-changed-files-%d-*.txt", i.iterationCount))
+	if err != nil {
+		return "", err
+	}
+	for _, file := range changed {
+		fmt.Fprintln(f, file)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	i.changedFilesMu.Lock()
+	i.changedFilesLists = append(i.changedFilesLists, f.Name())
+	i.changedFilesMu.Unlock()
+	return f.Name(), nil
+}
+
+// removeOldChangedFilesLists removes the changed files lists of the processes
+// replaced by the latest one, once one of them exits. Crash restarts reuse the
+// latest list.
+func (i *IBazel) removeOldChangedFilesLists() {
+	i.changedFilesMu.Lock()
+	defer i.changedFilesMu.Unlock()
+	if len(i.changedFilesLists) < 2 {
+		return
+	}
+	last := len(i.changedFilesLists) - 1
+	for _, list := range i.changedFilesLists[:last] {
+		os.Remove(list)
+	}
+	i.changedFilesLists = i.changedFilesLists[last:]
+}
+
+// handleOrphan terminates the process of the run target that a previous
+// iBazel left running when it was killed, if the user agrees.
+func (i *IBazel) handleOrphan() {
+	if i.session == nil || i.orphanedProcessAction == "ignore" {
+		return
+	}
+	orphan, err := i.session.Orphan()
+	if err != nil {
+		log.Errorf("Error reading the session of the run target: %v", err)
+		return
+	}
+	if orphan == nil {
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s is still running in process group %d.", orphan.Target, orphan.PGID),
+		fmt.Sprintf("It was started by iBazel (pid %d), which isn't running anymore.", orphan.IBazelPID),
+	}
+	if orphan.Cmdline != "" {
+		lines = append(lines, fmt.Sprintf("Command: %s", orphan.Cmdline))
+	}
+	log.Banner(lines...)
+	if i.orphanedProcessAction == "ask" && !confirm("Terminate it?") {
+		return
+	}
+	if err := i.session.Terminate(orphan); err != nil {
+		log.Errorf("Error terminating process group %d: %v", orphan.PGID, err)
+	}
+}
+
+// confirm asks a yes or no question on the terminal. Without a terminal, the
+// answer is no.
+func confirm(question string) bool {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		log.Log("Not terminating it since there is no terminal to ask for confirmation. Use --orphaned_process_action=kill to terminate it automatically.")
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [Y/n] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return true
+	}
+	return false
+}
+
 func (i *IBazel) queryRule(rule string) (*blaze_query.Rule, error) {
 	b := i.newBazel()
 
@@ -507,6 +1311,10 @@ func (i *IBazel) dumpRootRepoMapping() (map[string]string, *bytes.Buffer, error)
 }
 
 func (i *IBazel) queryForSourceFiles(targets string) ([]string, error) {
+	if i.watchDiscovery == "aquery" {
+		return i.aqueryForSourceFiles(targets)
+	}
+
 	b := i.newBazel()
 
 	res, err := b.CQuery(i.cQueryArgs(fmt.Sprintf(sourceQuery, targets))...)
@@ -529,6 +1337,21 @@ func (i *IBazel) queryForSourceFiles(targets string) ([]string, error) {
 	return i.labelsToWatch(labels)
 }
 
+// aqueryForSourceFiles finds the source files read by the actions of targets
+// in the current configuration. Unlike a query, it leaves out the sources of
+// tools and toolchains and includes inputs picked with select().
+func (i *IBazel) aqueryForSourceFiles(targets string) ([]string, error) {
+	b := i.newBazel()
+
+	inputs, err := b.AQueryInputs(fmt.Sprintf(targetQuery, targets))
+	if err != nil {
+		log.Errorf("Bazel aquery failed: %v", err)
+		return nil, err
+	}
+
+	return i.execPathsToWatch(inputs)
+}
+
 func (i *IBazel) queryForBuildFiles(targets string) ([]string, error) {
 	b := i.newBazel()
 
@@ -595,18 +1418,118 @@ func (i *IBazel) queryForBuildFiles(targets string) ([]string, error) {
 	return i.labelsToWatch(labels)
 }
 
+// loadIgnore reads the workspace's ignore file again, so that changes to it
+// apply from the next query on.
+func (i *IBazel) loadIgnore() {
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		log.Errorf("Error finding workspace: %v", err)
+		return
+	}
+	m, err := ignore.Load(workspacePath, i.ignorePatterns...)
+	if err != nil {
+		log.Errorf("Error loading the files to ignore:\n%v", err)
+	}
+	if m == nil {
+		// The ignore file couldn't be read, so only the flags apply.
+		m, _ = ignore.New(workspacePath, i.ignorePatterns...)
+	}
+	i.ignore = m
+
+	i.ignoreFile = filepath.Join(workspacePath, ignore.File)
+	if _, err := os.Stat(i.ignoreFile); err != nil {
+		i.ignoreFile = ""
+	}
+}
+
+// watchExtraFiles watches the files matched by --watch_extra and by the
+// workspace's watch_extra.ConfigFile.
+func (i *IBazel) watchExtraFiles() {
+	rules := append([]watch_extra.Rule{}, i.watchExtra...)
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		log.Errorf("Error finding workspace: %v", err)
+		return
+	}
+	config, err := watch_extra.LoadConfig(workspacePath)
+	if err != nil {
+		log.Errorf("Error loading extra files to watch: %v", err)
+	}
+	rules = append(rules, config...)
+
+	i.extraRules, err = watch_extra.Resolve(rules, workspacePath)
+	if err != nil {
+		log.Errorf("Error resolving extra files to watch: %v", err)
+		i.extraRules = nil
+	}
+	if err := i.extraFileWatcher.UpdateAll(watch_extra.Dirs(i.extraRules)); err != nil {
+		log.Errorf("Error(s) updating the watch list of extra files:\n %v", err)
+	}
+}
+
 func (i *IBazel) watchFiles(toWatch []string, watcher common.Watcher) {
+	i.watchFilesIgnoring(toWatch, watcher, i.ignore, true)
+}
+
+// watchNamedFiles watches files the user named, such as the --env_file ones
+// or the Bazel configuration, which the ignore patterns don't apply to: an
+// ignore file copied from .gitignore commonly lists .env or user.bazelrc.
+// Missing ones, such as an optional try-import, are watched for quietly.
+func (i *IBazel) watchNamedFiles(toWatch []string, watcher common.Watcher) {
+	i.watchFilesIgnoring(toWatch, watcher, nil, false)
+}
+
+// watchFilesIgnoring watches toWatch, except for the files matched by ignored.
+// reportMissing logs the files that don't exist yet.
+func (i *IBazel) watchFilesIgnoring(toWatch []string, watcher common.Watcher, ignored *ignore.Matcher, reportMissing bool) {
 	filesWatched := map[string]struct{}{}
+	filesMissing := map[string]struct{}{}
+	symlinks := map[string]struct{}{}
 	uniqueDirectories := map[string]struct{}{}
 
+	// Symbolic links outside the workspace, such as /tmp on macOS, are left
+	// alone to keep the watched directories within it.
+	workspacePath := ""
+	if ws, err := i.workspaceFinder.FindWorkspace(); err == nil {
+		workspacePath, _ = filepath.EvalSymlinks(ws)
+	}
+
+	ignoredCount := 0
+
 	for _, file := range toWatch {
+		if workspacePath != "" {
+			hops, _ := symlinkHops(file)
+			for _, hop := range hops {
+				if strings.HasPrefix(hop, workspacePath+string(filepath.Separator)) {
+					symlinks[hop] = struct{}{}
+					uniqueDirectories[filepath.Dir(hop)+string(filepath.Separator)] = struct{}{}
+				}
+			}
+		}
+
 		path, err := filepath.EvalSymlinks(file)
+		missing := false
+		if os.IsNotExist(err) {
+			// Watch the directory the file will be created in.
+			var dir string
+			if dir, err = filepath.EvalSymlinks(filepath.Dir(file)); err == nil {
+				path = filepath.Join(dir, filepath.Base(file))
+				missing = true
+			}
+		}
 		if err != nil {
 			log.Errorf("Error evaluating symbolic links for source file: %v", err)
 			continue
 		}
 
-		if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if ignored.Ignored(file) || ignored.Ignored(path) {
+			ignoredCount++
+			continue
+		}
+
+		if missing {
+			filesMissing[path] = struct{}{}
+		} else {
 			filesWatched[path] = struct{}{}
 		}
 
@@ -615,19 +1538,87 @@ func (i *IBazel) watchFiles(toWatch []string, watcher common.Watcher) {
 		uniqueDirectories[parentDirectory] = struct{}{}
 	}
 
+	if ignoredCount > 0 {
+		log.Logf("Ignoring %d file(s) matched by %s or --ignore", ignoredCount, ignore.File)
+	}
+
 	watchList := keys(uniqueDirectories)
 	err := watcher.UpdateAll(watchList)
 	if err != nil {
 		log.Errorf("Error(s) updating watch list:\n %v", err)
 	}
 
-	if len(filesWatched) == 0 {
+	if len(filesMissing) > 0 && reportMissing {
+		missing := keys(filesMissing)
+		sort.Strings(missing)
+		if len(missing) > maxMissingLogged {
+			missing = append(missing[:maxMissingLogged], "...")
+		}
+		log.Errorf("%d declared file(s) don't exist yet, watching for them to be created:\n  %s", len(filesMissing), strings.Join(missing, "\n  "))
+	}
+
+	if len(filesWatched) == 0 && len(filesMissing) == 0 {
 		log.Errorf("Didn't find any files to watch for")
 	}
 
 	i.filesWatched[watcher] = filesWatched
+	i.filesMissing[watcher] = filesMissing
+	i.symlinks[watcher] = symlinks
 }
 
+// maxSymlinkHops is how many symbolic links symlinkHops follows before giving
+// up, like the limit of Linux.
+const maxSymlinkHops = 40
+
+// symlinkHops returns the symbolic links followed to resolve path, in order.
+// Each is returned with its parent directory resolved, as the watchers report
+// it. Links after a missing one aren't returned.
+func symlinkHops(path string) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.VolumeName(path) + string(filepath.Separator)
+
+	var hops []string
+	resolved := root
+	rest := strings.Split(path[len(root):], string(filepath.Separator))
+	for len(rest) > 0 {
+		next := rest[0]
+		rest = rest[1:]
+		if next == "" || next == "." {
+			continue
+		}
+		name := filepath.Join(resolved, next)
+		info, err := os.Lstat(name)
+		if err != nil {
+			return hops, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = name
+			continue
+		}
+
+		if len(hops) == maxSymlinkHops {
+			return hops, fmt.Errorf("too many symbolic links resolving %s", path)
+		}
+		hops = append(hops, name)
+		target, err := os.Readlink(name)
+		if err != nil {
+			return hops, err
+		}
+		if filepath.IsAbs(target) {
+			resolved = filepath.VolumeName(target) + string(filepath.Separator)
+			target = target[len(resolved):]
+		}
+		rest = append(strings.Split(target, string(filepath.Separator)), rest...)
+	}
+	return hops, nil
+}
+
+// maxMissingLogged is how many missing files watchFiles lists.
+const maxMissingLogged = 10
+
 func (i *IBazel) labelsToWatch(labels []string) ([]string, error) {
 	localRepositories, err := i.realLocalRepositoryPaths()
 	if err != nil {
@@ -641,15 +1632,24 @@ func (i *IBazel) labelsToWatch(labels []string) ([]string, error) {
 	}
 
 	toWatch := make([]string, 0, len(labels))
+	excluded := 0
 	for _, label := range labels {
+		if !i.watchFilter.Watched(label) {
+			excluded++
+			continue
+		}
 		if strings.HasPrefix(label, "@") {
 			repo, target := parseTarget(label)
-			if realPath, ok := localRepositories[repo]; ok {
+			if repo == "" {
+				// @//pkg:file and @@//pkg:file are in the main repository.
+				label = "//" + target
+			} else if realPath, ok := localRepositories[repo]; ok {
 				label = strings.Replace(target, ":", string(filepath.Separator), 1)
 				toWatch = append(toWatch, filepath.Join(realPath, label))
-				break
+				continue
+			} else {
+				continue
 			}
-			continue
 		}
 		if strings.HasPrefix(label, "//external") {
 			continue
@@ -659,15 +1659,196 @@ func (i *IBazel) labelsToWatch(labels []string) ([]string, error) {
 		toWatch = append(toWatch, filepath.Join(workspacePath, label))
 	}
 
+	if excluded > 0 {
+		log.Logf("Not watching %d file(s) excluded by --watch_only or --watch_exclude", excluded)
+	}
 	return toWatch, nil
 }
 
+// repositoryOverrides returns the paths of the repositories overridden with
+// --override_repository, of the modules overridden with --override_module and
+// of the local repositories, such as the ones of local_path_override, by name.
+// The flags take precedence.
+func (i *IBazel) repositoryOverrides() map[string]string {
+	overrides := map[string]string{}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return overrides
+	}
+
+	for name, path := range i.localPathOverrides(workspacePath) {
+		overrides[name] = path
+	}
+	// https://bazel.build/external/overview#overriding-repositories-from-the-command-line
+	for _, arg := range i.bazelArgs {
+		var override string
+		if strings.HasPrefix(arg, "--override_repository=") {
+			override = strings.TrimPrefix(arg, "--override_repository=")
+		} else if strings.HasPrefix(arg, "--override_module=") {
+			override = strings.TrimPrefix(arg, "--override_module=")
+		} else {
+			continue
+		}
+		name, path, ok := strings.Cut(override, "=")
+		if !ok || name == "" || path == "" {
+			log.Errorf("ibazel cannot parse argument: %v", arg)
+			continue
+		}
+		path = strings.ReplaceAll(path, "%workspace%", workspacePath)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workspacePath, path)
+		}
+		overrides[strings.TrimLeft(name, "@")] = filepath.Clean(path)
+	}
+	return overrides
+}
+
+// localPathOverrides returns the paths of the local repositories Bazel
+// defines, such as the ones of local_path_override, by canonical name. When
+// bazel mod can't show them, MODULE.bazel is scanned for local_path_override
+// instead, by module name.
+func (i *IBazel) localPathOverrides(workspacePath string) map[string]string {
+	repos, err := i.showRootRepos()
+	if err != nil {
+		return repository_inputs.LocalPathOverrides(workspacePath)
+	}
+	return repository_inputs.LocalRepositories(workspacePath, repos)
+}
+
+// addRepositoryAliases makes the local repositories known by both their
+// apparent name in the main repository and their canonical name, as labels
+// may use either.
+func (i *IBazel) addRepositoryAliases(localRepositories map[string]string) {
+	if len(localRepositories) == 0 {
+		return
+	}
+	repoMapping, _, err := i.dumpRootRepoMapping()
+	if err != nil {
+		// Don't fail on this. We will try without the mapping.
+		log.Errorf("Error finding repository mapping: %v\n", err)
+		return
+	}
+	for apparent, canonical := range repoMapping {
+		// Module overrides are named after the module, which starts the
+		// canonical name of its repository, e.g. "lib~" or "lib+".
+		module := canonical
+		if i := strings.IndexAny(canonical, "~+"); i > 0 {
+			module = canonical[:i]
+		}
+		for _, name := range []string{apparent, canonical, module} {
+			if realPath, ok := localRepositories[name]; ok {
+				for _, alias := range []string{apparent, canonical} {
+					if _, ok := localRepositories[alias]; !ok {
+						localRepositories[alias] = realPath
+					}
+				}
+				break
+			}
+		}
+	}
+}
+
+// execPathsToWatch maps paths relative to the execution root to the source
+// files in the workspace and local repositories. Generated files are left out.
+func (i *IBazel) execPathsToWatch(paths []string) ([]string, error) {
+	localRepositories, err := i.realLocalRepositoryPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		log.Errorf("Error finding workspace: %v", err)
+		return nil, err
+	}
+
+	toWatch := make([]string, 0, len(paths))
+	excluded := 0
+	packages := map[string]string{}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "bazel-out/") {
+			continue
+		}
+		root, repo, file := workspacePath, "", path
+		// External repositories are under external/, or next to the main
+		// repository with --experimental_sibling_repository_layout.
+		if rest, ok := cutAnyPrefix(path, "external/", "../"); ok {
+			repo, file, _ = strings.Cut(rest, "/")
+			realPath, ok := localRepositories[repo]
+			if !ok {
+				continue
+			}
+			root = realPath
+		}
+		if !i.watchFilter.Empty() && !i.watchFilter.Watched(sourceLabel(root, repo, file, packages)) {
+			excluded++
+			continue
+		}
+		toWatch = append(toWatch, filepath.Join(root, filepath.FromSlash(file)))
+	}
+
+	if excluded > 0 {
+		log.Logf("Not watching %d file(s) excluded by --watch_only or --watch_exclude", excluded)
+	}
+	return toWatch, nil
+}
+
+// sourceLabel returns the label of file, a slash separated path in the
+// repository named repo whose files are in root. Its package is the closest
+// directory with a BUILD file. packages caches the package of directories.
+func sourceLabel(root, repo, file string, packages map[string]string) string {
+	pkg := findPackage(root, filepath.ToSlash(filepath.Dir(filepath.FromSlash(file))), packages)
+	name := file
+	if pkg != "" {
+		name = strings.TrimPrefix(file, pkg+"/")
+	}
+	label := "//" + pkg + ":" + name
+	if repo != "" {
+		label = "@" + repo + label
+	}
+	return label
+}
+
+func findPackage(root, dir string, packages map[string]string) string {
+	if dir == "." || dir == "" {
+		return ""
+	}
+	key := filepath.Join(root, filepath.FromSlash(dir))
+	if pkg, ok := packages[key]; ok {
+		return pkg
+	}
+	pkg := dir
+	if !hasBuildFile(key) {
+		pkg = findPackage(root, filepath.ToSlash(filepath.Dir(filepath.FromSlash(dir))), packages)
+	}
+	packages[key] = pkg
+	return pkg
+}
+
+func hasBuildFile(dir string) bool {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func cutAnyPrefix(s string, prefixes ...string) (string, bool) {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return strings.TrimPrefix(s, prefix), true
+		}
+	}
+	return s, false
+}
+
 func (i *IBazel) queryArgs(args ...string) []string {
 	queryArgs := append([]string(nil), args...)
 
 	for _, arg := range i.bazelArgs {
 		// List of args that should be passed to bazel query/cquery.
-		if strings.HasPrefix(arg, "--override_repository=") {
+		if strings.HasPrefix(arg, "--override_repository=") || strings.HasPrefix(arg, "--override_module=") {
 			queryArgs = append(queryArgs, arg)
 		}
 	}
@@ -683,7 +1864,8 @@ func (i *IBazel) cQueryArgs(args ...string) []string {
 }
 
 func parseTarget(label string) (repo string, target string) {
-	parts := strings.Split(strings.TrimPrefix(label, "@"), "//")
+	// Canonical labels start with @@.
+	parts := strings.SplitN(strings.TrimLeft(label, "@"), "//", 2)
 	return parts[0], parts[1]
 }
 
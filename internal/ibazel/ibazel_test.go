@@ -16,12 +16,14 @@ package ibazel
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -32,6 +34,7 @@ import (
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/command"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/fswatcher/common"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/repository_inputs"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/workspace"
 
 	mock_bazel "github.com/bazelbuild/bazel-watcher/internal/bazel/testing"
@@ -86,6 +89,8 @@ type mockCommand struct {
 	notifiedOfChanges bool
 	started           bool
 	terminated        bool
+	restarted         bool
+	env               []string
 
 	signalChan  chan syscall.Signal
 	doTermChan  chan struct{}
@@ -136,6 +141,23 @@ func (m *mockCommand) assertSignal(t *testing.T, signum syscall.Signal) {
 func (m *mockCommand) IsSubprocessRunning() bool {
 	return m.started && !m.terminated
 }
+func (m *mockCommand) SetExitHandler(handler command.ExitHandler)   {}
+func (m *mockCommand) SetStartHandler(handler command.StartHandler) {}
+func (m *mockCommand) SetEnv(env []string) {
+	m.env = env
+}
+func (m *mockCommand) SetIteration(iteration int) {}
+func (m *mockCommand) Restart() error {
+	if !m.started {
+		return errors.New("not started")
+	}
+	m.restarted = true
+	return nil
+}
+func (m *mockCommand) Signal(signum syscall.Signal) error {
+	m.signalChan <- signum
+	return nil
+}
 
 func getMockCommand(i *IBazel) *mockCommand {
 	c, ok := i.cmd.(*mockCommand)
@@ -146,7 +168,7 @@ func getMockCommand(i *IBazel) *mockCommand {
 }
 
 func init() {
-	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string) command.Command {
+	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string, opts command.Options) command.Command {
 		// Don't do anything
 		return &mockCommand{
 			startupArgs: startupArgs,
@@ -337,6 +359,477 @@ func TestIBazelLoop(t *testing.T) {
 	step() // Actually run the command
 	assertRun()
 	assertState(WAIT)
+	// Metadata only change.
+	i.sourceFileWatcher.Events() <- common.Event{Op: common.Chmod, Name: sourceFilePath}
+	step()
+	assertState(WAIT)
+	// Source file moved away.
+	i.sourceFileWatcher.Events() <- common.Event{Op: common.Rename | common.Create, Name: sourceFilePath + ".bak", OldName: sourceFilePath}
+	step()
+	assertState(DEBOUNCE_RUN)
+	assertEqual(t, map[string]struct{}{sourceFilePath: {}}, i.changedFiles, "changed files")
+}
+
+func TestIBazelLoop_envFileChange(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("GREETING=hello\n"), 0o600); err != nil {
+		t.Fatalf("failed to create env file: %v", err)
+	}
+	i.SetEnvFiles([]string{envFile})
+
+	fakeEnvWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.envFileWatcher = fakeEnvWatcher
+	i.filesWatched[fakeEnvWatcher] = map[string]struct{}{envFile: {}}
+
+	cmd := &mockCommand{started: true}
+	i.cmd = cmd
+
+	called := false
+	command := func(targets ...string) (*bytes.Buffer, error) {
+		called = true
+		return nil, nil
+	}
+	step := func() {
+		i.iteration("run", command, []string{"//my:target"}, "//my:target")
+	}
+
+	i.state = WAIT
+	i.envFileWatcher.Events() <- common.Event{Op: common.Write, Name: envFile}
+	step()
+	assertEqual(t, DEBOUNCE_RESTART, i.state, "State after an env file change")
+	step()
+	assertEqual(t, RESTART, i.state, "State after debouncing")
+	step()
+	assertEqual(t, WAIT, i.state, "State after restarting")
+
+	if called {
+		t.Errorf("An env file change should not rebuild the target")
+	}
+	if !cmd.restarted {
+		t.Errorf("An env file change should restart the target")
+	}
+	if len(cmd.env) == 0 || cmd.env[0] != "GREETING=hello" {
+		t.Errorf("The target didn't get the variables of the env file: %v", cmd.env)
+	}
+}
+
+func TestIBazelLoop_extraFileChange(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir := t.TempDir()
+	if err := i.SetWatchExtra([]string{
+		filepath.Join(dir, "*.json"),
+		filepath.Join(dir, "*.pem") + "=rebuild",
+		filepath.Join(dir, "*.txt") + "=requery",
+	}); err != nil {
+		t.Fatalf("SetWatchExtra(): %v", err)
+	}
+	i.watchExtraFiles()
+
+	fakeExtraWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.extraFileWatcher = fakeExtraWatcher
+
+	command := func(targets ...string) (*bytes.Buffer, error) {
+		return nil, nil
+	}
+	for file, want := range map[string]State{
+		"config.json": DEBOUNCE_RESTART,
+		"server.pem":  DEBOUNCE_RUN,
+		"deps.txt":    DEBOUNCE_QUERY,
+		"README.md":   WAIT,
+	} {
+		i.state = WAIT
+		i.extraFileWatcher.Events() <- common.Event{Op: common.Write, Name: filepath.Join(dir, file)}
+		i.iteration("run", command, []string{"//my:target"}, "//my:target")
+		assertEqual(t, want, i.state, "State after changing "+file)
+	}
+}
+
+func TestIBazelWatchFiles_ignore(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "service.go")
+	generated := filepath.Join(dir, "service.pb.go")
+	for _, file := range []string{source, generated} {
+		if err := os.WriteFile(file, nil, 0o600); err != nil {
+			t.Fatalf("failed to create %s: %v", file, err)
+		}
+	}
+	// The temporary directory may be behind a symlink.
+	source, _ = filepath.EvalSymlinks(source)
+
+	i.SetIgnorePatterns([]string{"*.pb.go", ".env"})
+	i.loadIgnore()
+	fakeWatcher := &fakeFSNotifyWatcher{}
+	i.watchFiles([]string{source, generated}, fakeWatcher)
+
+	assertEqual(t, map[string]struct{}{source: {}}, i.filesWatched[fakeWatcher], "Watched files")
+
+	// Files the user named, like --env_file ones, are watched even if an
+	// ignore file copied from .gitignore lists them.
+	envFile := filepath.Join(filepath.Dir(source), ".env")
+	if err := os.WriteFile(envFile, nil, 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", envFile, err)
+	}
+	envWatcher := &fakeFSNotifyWatcher{}
+	i.watchNamedFiles([]string{envFile}, envWatcher)
+	assertEqual(t, map[string]struct{}{envFile: {}}, i.filesWatched[envWatcher], "Watched named files")
+}
+
+func TestIBazelLoop_missingFile(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	existing := filepath.Join(dir, "main.go")
+	missing := filepath.Join(dir, "generated.go")
+	if err := os.WriteFile(existing, nil, 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", existing, err)
+	}
+
+	fakeWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.sourceFileWatcher = fakeWatcher
+	i.watchFiles([]string{existing, missing}, fakeWatcher)
+	assertEqual(t, map[string]struct{}{existing: {}}, i.filesWatched[fakeWatcher], "Watched files")
+	assertEqual(t, map[string]struct{}{missing: {}}, i.filesMissing[fakeWatcher], "Missing files")
+
+	step := func() {
+		i.iteration("build", func(targets ...string) (*bytes.Buffer, error) { return nil, nil }, []string{"//my:target"}, "//my:target")
+	}
+
+	i.state = WAIT
+	fakeWatcher.EventChan <- common.Event{Op: common.Write, Name: missing}
+	step()
+	assertEqual(t, WAIT, i.state, "State after writing a file that wasn't created")
+
+	fakeWatcher.EventChan <- common.Event{Op: common.Create, Name: missing}
+	step()
+	assertEqual(t, DEBOUNCE_RUN, i.state, "State after creating a missing file")
+	assertEqual(t, map[string]struct{}{existing: {}, missing: {}}, i.filesWatched[fakeWatcher], "Watched files")
+	assertEqual(t, map[string]struct{}{}, i.filesMissing[fakeWatcher], "Missing files")
+}
+
+func TestSymlinkHops(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.Mkdir(filepath.Join(dir, "config.dev"), 0o700))
+	must(os.WriteFile(filepath.Join(dir, "config.dev", "app.yaml"), nil, 0o600))
+	must(os.Symlink("config.dev", filepath.Join(dir, "config")))
+	must(os.Symlink(filepath.Join(dir, "config", "app.yaml"), filepath.Join(dir, "app.yaml")))
+	must(os.Symlink("app.yaml", filepath.Join(dir, "current.yaml")))
+
+	hops, err := symlinkHops(filepath.Join(dir, "current.yaml"))
+	must(err)
+	assertEqual(t, []string{
+		filepath.Join(dir, "current.yaml"),
+		filepath.Join(dir, "app.yaml"),
+		filepath.Join(dir, "config"),
+	}, hops, "Symlink hops")
+
+	must(os.Symlink("loop", filepath.Join(dir, "loop")))
+	if _, err := symlinkHops(filepath.Join(dir, "loop")); err == nil {
+		t.Errorf("Expected an error resolving a symlink loop")
+	}
+}
+
+func TestIBazelLoop_symlinkRetarget(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: dir}
+	link := filepath.Join(dir, "config")
+	for _, file := range []string{"config.dev", "config.prod"} {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0o600); err != nil {
+			t.Fatalf("failed to create %s: %v", file, err)
+		}
+	}
+	if err := os.Symlink("config.dev", link); err != nil {
+		t.Fatalf("failed to create %s: %v", link, err)
+	}
+
+	fakeWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.sourceFileWatcher = fakeWatcher
+	i.watchFiles([]string{link}, fakeWatcher)
+	assertEqual(t, map[string]struct{}{filepath.Join(dir, "config.dev"): {}}, i.filesWatched[fakeWatcher], "Watched files")
+	assertEqual(t, map[string]struct{}{link: {}}, i.symlinks[fakeWatcher], "Watched symlinks")
+
+	i.state = WAIT
+	fakeWatcher.EventChan <- common.Event{Op: common.Create | common.Rename, Name: link, OldName: link + ".tmp"}
+	i.iteration("build", func(targets ...string) (*bytes.Buffer, error) { return nil, nil }, []string{"//my:target"}, "//my:target")
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State after retargeting a symlink")
+}
+
+func TestIBazelLoop_repositoryInputChange(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	module := filepath.Join(dir, "MODULE.bazel")
+	goMod := filepath.Join(dir, "go.mod")
+	if err := os.WriteFile(module, []byte(`go_deps.from_file(go_mod = "//:go.mod")`), 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", module, err)
+	}
+	if err := os.WriteFile(goMod, nil, 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", goMod, err)
+	}
+	i.setRepositoryInputs(repository_inputs.Find(dir))
+	i.SetRefetch(true)
+
+	fakeWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.buildFileWatcher = fakeWatcher
+	i.filesWatched[fakeWatcher] = map[string]struct{}{module: {}, goMod: {}}
+
+	i.state = WAIT
+	fakeWatcher.EventChan <- common.Event{Op: common.Write, Name: goMod}
+	i.iteration("build", func(targets ...string) (*bytes.Buffer, error) { return nil, nil }, []string{"//my:target"}, "//my:target")
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State after a repository input changed")
+	assertEqual(t, true, i.refetchPending, "Refetch pending")
+
+	i.refetchRepositories([]string{"//my:target"})
+	mockBazel.AssertActions(t, [][]string{
+		{"SetStartupArgs"},
+		{"SetArguments"},
+		{"Info"},
+		{"SetStartupArgs"},
+		{"SetArguments"},
+		{"Fetch", "--force", "//my:target"},
+	})
+	assertEqual(t, false, i.refetchPending, "Refetch pending")
+}
+
+func TestIBazelLoop_configChange(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	rc := filepath.Join(dir, ".bazelrc")
+	userRC := filepath.Join(dir, "user.bazelrc")
+	version := filepath.Join(dir, ".bazelversion")
+	for file, content := range map[string]string{
+		rc:      "try-import %workspace%/user.bazelrc\n",
+		userRC:  "build --jobs=8\n",
+		version: "7.0.0\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to create %s: %v", file, err)
+		}
+	}
+
+	fakeWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.configFileWatcher = fakeWatcher
+	i.SetStartupArgs([]string{"--nosystem_rc", "--nohome_rc"})
+	i.watchConfigFiles(dir)
+	assertEqual(t, map[string]struct{}{rc: {}, userRC: {}, version: {}}, i.filesWatched[fakeWatcher], "Watched files")
+	assertEqual(t, "7.0.0", i.bazelVersion, "Bazel version")
+
+	i.state = WAIT
+	fakeWatcher.EventChan <- common.Event{Op: common.Write, Name: userRC}
+	i.iteration("build", func(targets ...string) (*bytes.Buffer, error) { return nil, nil }, []string{"//my:target"}, "//my:target")
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State after an rc file changed")
+
+	if err := os.WriteFile(version, []byte("7.1.0\n"), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", version, err)
+	}
+	i.watchConfigFiles(dir)
+	assertEqual(t, "7.1.0", i.bazelVersion, "Bazel version")
+}
+
+func TestIBazelLoop_configCreated(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	rc := filepath.Join(dir, ".bazelrc")
+	userRC := filepath.Join(dir, "user.bazelrc")
+	version := filepath.Join(dir, ".bazelversion")
+	if err := os.WriteFile(rc, []byte("try-import %workspace%/user.bazelrc\n"), 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", rc, err)
+	}
+
+	fakeWatcher := &fakeFSNotifyWatcher{
+		EventChan: make(chan common.Event, 1),
+	}
+	i.configFileWatcher = fakeWatcher
+	i.SetStartupArgs([]string{"--nosystem_rc", "--nohome_rc"})
+	i.watchConfigFiles(dir)
+	assertEqual(t, map[string]struct{}{userRC: {}, version: {}}, i.filesMissing[fakeWatcher], "Missing files")
+
+	// The try-import target is created after iBazel started.
+	if err := os.WriteFile(userRC, []byte("build --jobs=8\n"), 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", userRC, err)
+	}
+	i.state = WAIT
+	fakeWatcher.EventChan <- common.Event{Op: common.Create, Name: userRC}
+	i.iteration("build", func(targets ...string) (*bytes.Buffer, error) { return nil, nil }, []string{"//my:target"}, "//my:target")
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State after a try-import target was created")
+}
+
+func TestIBazelLoop_vcsOperation(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	i.gitDir = t.TempDir()
+	indexLock := filepath.Join(i.gitDir, "index.lock")
+	if err := os.WriteFile(indexLock, nil, 0o600); err != nil {
+		t.Fatalf("failed to create index.lock: %v", err)
+	}
+
+	called := false
+	command := func(targets ...string) (*bytes.Buffer, error) {
+		called = true
+		return nil, nil
+	}
+	step := func() {
+		i.iteration("build", command, []string{"//my:target"}, "//my:target")
+	}
+
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, VCS_WAIT, i.state, "State while git holds the index")
+	step()
+	assertEqual(t, VCS_WAIT, i.state, "State while git still holds the index")
+
+	os.Remove(indexLock)
+	step()
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State once git released the index")
+	if called {
+		t.Errorf("Nothing should have been built while git held the index")
+	}
+}
+
+func TestIBazelLoop_staleVCSLock(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	// A lock left behind by a git command that was killed long ago.
+	i.gitDir = t.TempDir()
+	indexLock := filepath.Join(i.gitDir, "index.lock")
+	if err := os.WriteFile(indexLock, nil, 0o600); err != nil {
+		t.Fatalf("failed to create index.lock: %v", err)
+	}
+	old := time.Now().Add(-2 * vcsStaleLock)
+	if err := os.Chtimes(indexLock, old, old); err != nil {
+		t.Fatalf("failed to age index.lock: %v", err)
+	}
+
+	called := false
+	command := func(targets ...string) (*bytes.Buffer, error) {
+		called = true
+		return nil, nil
+	}
+	step := func() {
+		i.iteration("build", command, []string{"//my:target"}, "//my:target")
+	}
+
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, RUN, i.state, "State with a stale index.lock")
+	step()
+	if !called {
+		t.Errorf("A stale index.lock should not hold the build")
+	}
+
+	// A lock that goes stale while iBazel waits on it releases the hold too.
+	if err := os.Chtimes(indexLock, time.Now(), time.Now()); err != nil {
+		t.Fatalf("failed to touch index.lock: %v", err)
+	}
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, VCS_WAIT, i.state, "State while git holds the index")
+	if err := os.Chtimes(indexLock, old, old); err != nil {
+		t.Fatalf("failed to age index.lock: %v", err)
+	}
+	step()
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State once index.lock went stale")
+}
+
+func TestIBazelLoop_vcsWaitTimeout(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+	i.SetVCSWaitTimeout(time.Millisecond)
+
+	// A merge waiting for the user to resolve conflicts.
+	i.gitDir = t.TempDir()
+	mergeHead := filepath.Join(i.gitDir, "MERGE_HEAD")
+	if err := os.WriteFile(mergeHead, nil, 0o600); err != nil {
+		t.Fatalf("failed to create MERGE_HEAD: %v", err)
+	}
+
+	command := func(targets ...string) (*bytes.Buffer, error) {
+		return nil, nil
+	}
+	step := func() {
+		i.iteration("build", command, []string{"//my:target"}, "//my:target")
+	}
+
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, VCS_WAIT, i.state, "State while a merge is in progress")
+	time.Sleep(10 * time.Millisecond)
+	step()
+	assertEqual(t, DEBOUNCE_QUERY, i.state, "State once the merge outlasted --vcs_wait_timeout")
+
+	// The same merge doesn't hold iterations again.
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, RUN, i.state, "State during the merge that timed out")
+
+	// The next one does.
+	os.Remove(mergeHead)
+	i.state = DEBOUNCE_RUN
+	step()
+	if err := os.WriteFile(mergeHead, nil, 0o600); err != nil {
+		t.Fatalf("failed to create MERGE_HEAD: %v", err)
+	}
+	i.state = DEBOUNCE_RUN
+	step()
+	assertEqual(t, VCS_WAIT, i.state, "State during the next merge")
 }
 
 func TestIBazelBuild(t *testing.T) {
@@ -420,7 +913,7 @@ func TestIBazelTest(t *testing.T) {
 func TestIBazelRun_notifyPreexistiingJobWhenStarting(t *testing.T) {
 	log.SetTesting(t)
 
-	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string) command.Command {
+	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string, opts command.Options) command.Command {
 		assertEqual(t, startupArgs, []string{}, "Startup args")
 		assertEqual(t, bazelArgs, []string{}, "Bazel args")
 		assertEqual(t, target, "", "Target")
@@ -447,6 +940,126 @@ func TestIBazelRun_notifyPreexistiingJobWhenStarting(t *testing.T) {
 	}
 }
 
+func TestIBazelSetupRun_debounce(t *testing.T) {
+	log.SetTesting(t)
+
+	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string, opts command.Options) command.Command {
+		return &mockCommand{}
+	}
+	defer func() { commandDefaultCommand = oldCommandDefaultCommand }()
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+	i.SetDebounceDuration(200 * time.Millisecond)
+
+	for target, tags := range map[string][]string{
+		"//path/to:tagged":   {"ibazel_debounce=2s"},
+		"//path/to:untagged": {},
+	} {
+		mockBazel.AddCQueryResponse(target, &analysispb.CqueryResult{
+			Results: []*analysispb.ConfiguredTarget{{
+				Target: &blaze_query.Target{
+					Type: blaze_query.Target_RULE.Enum(),
+					Rule: &blaze_query.Rule{
+						Name: proto.String(target),
+						Attribute: []*blaze_query.Attribute{{
+							Name:            proto.String("tags"),
+							Type:            blaze_query.Attribute_STRING_LIST.Enum(),
+							StringListValue: tags,
+						}},
+					},
+				},
+			}},
+		})
+	}
+
+	i.setupRun("//path/to:tagged")
+	assertEqual(t, 2*time.Second, i.debounce(), "Debounce of the tagged target")
+	i.setupRun("//path/to:untagged")
+	assertEqual(t, 200*time.Millisecond, i.debounce(), "Debounce of the untagged target")
+}
+
+func TestIBazelRun_environment(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{}
+	i.cmd = cmd
+	i.iterationCount = 2
+	i.changeDetected(nil, "source", "/a/b.go")
+	i.changeDetected(nil, "source", "/a/a.go")
+
+	i.run("//path/to:target")
+
+	env := map[string]string{}
+	for _, kv := range cmd.env {
+		parts := strings.SplitN(kv, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	assertEqual(t, "2", env["IBAZEL_ITERATION"], "IBAZEL_ITERATION")
+	assertEqual(t, "//path/to:target", env["IBAZEL_TARGET"], "IBAZEL_TARGET")
+	assertEqual(t, "run", env["IBAZEL_COMMAND"], "IBAZEL_COMMAND")
+	changed := "/a/a.go" + string(os.PathListSeparator) + "/a/b.go"
+	assertEqual(t, changed, env["IBAZEL_CHANGED_FILES"], "IBAZEL_CHANGED_FILES")
+
+	list, err := os.ReadFile(env["IBAZEL_CHANGED_FILES_LIST"])
+	if err != nil {
+		t.Fatalf("Reading IBAZEL_CHANGED_FILES_LIST: %v", err)
+	}
+	assertEqual(t, "/a/a.go\n/a/b.go\n", string(list), "IBAZEL_CHANGED_FILES_LIST contents")
+}
+
+func TestIBazelRun_changedFilesListPerProcess(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{}
+	i.cmd = cmd
+	list := func() string {
+		for _, kv := range cmd.env {
+			if strings.HasPrefix(kv, "IBAZEL_CHANGED_FILES_LIST=") {
+				return strings.TrimPrefix(kv, "IBAZEL_CHANGED_FILES_LIST=")
+			}
+		}
+		t.Fatalf("IBAZEL_CHANGED_FILES_LIST isn't set in %v", cmd.env)
+		return ""
+	}
+
+	i.iterationCount = 1
+	i.changeDetected(nil, "source", "/a/old.go")
+	i.run("//path/to:target")
+	old := list()
+
+	// With --run_overlap the old process keeps running, and reading its list,
+	// while the new one starts.
+	i.iterationCount = 2
+	i.changedFiles = nil
+	i.changeDetected(nil, "source", "/a/new.go")
+	i.run("//path/to:target")
+	current := list()
+	if old == current {
+		t.Fatalf("Both iterations got the changed files list %s", current)
+	}
+	contents, err := os.ReadFile(old)
+	if err != nil {
+		t.Fatalf("Reading the old list: %v", err)
+	}
+	assertEqual(t, "/a/old.go\n", string(contents), "Old IBAZEL_CHANGED_FILES_LIST contents")
+
+	// Once the old process exited, its list is removed.
+	i.removeOldChangedFilesLists()
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("The old list %s wasn't removed: %v", old, err)
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("The current list %s was removed: %v", current, err)
+	}
+}
+
 func TestHandleSignals_SIGINTWithoutRunningCommand(t *testing.T) {
 	log.SetTesting(t)
 	log.FakeExit()
@@ -629,6 +1242,43 @@ func TestHandleSignals_SIGTERM(t *testing.T) {
 	assertOsExited(t, osExitChan)
 }
 
+func TestHandleSignals_Forwarded(t *testing.T) {
+	log.SetTesting(t)
+
+	if len(forwardedSignals) == 0 {
+		t.Skip("No signals are forwarded on this platform")
+	}
+
+	i := &IBazel{}
+	err := i.setup()
+	if err != nil {
+		t.Errorf("Error creating IBazel: %s", err)
+	}
+	i.sigs = make(chan os.Signal, 1)
+	defer i.Cleanup()
+
+	osExitChan := make(chan int, 1)
+	osExit = func(i int) {
+		osExitChan <- i
+	}
+
+	cmd := &mockCommand{
+		signalChan: make(chan syscall.Signal, 10),
+	}
+	i.cmd = cmd
+	cmd.Start()
+
+	sig := forwardedSignals[0].(syscall.Signal)
+	i.sigs <- sig
+	i.handleSignals()
+	cmd.assertSignal(t, sig)
+	select {
+	case <-osExitChan:
+		t.Errorf("A forwarded signal shouldn't stop iBazel")
+	default:
+	}
+}
+
 func TestParseTarget(t *testing.T) {
 	log.SetTesting(t)
 
@@ -640,6 +1290,8 @@ func TestParseTarget(t *testing.T) {
 		{"@//my:target", "", "my:target"},
 		{"@repo//my:target", "repo", "my:target"},
 		{"@bazel_tools//:strange/target", "bazel_tools", ":strange/target"},
+		{"@@//my:target", "", "my:target"},
+		{"@@rules_go~//go:def.bzl", "rules_go~", "go:def.bzl"},
 	}
 	for _, test := range tests {
 		t.Run(test.in, func(t *testing.T) {
@@ -653,3 +1305,247 @@ func TestParseTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestIBazel_findRepositoryInputs(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	for _, name := range []string{"MODULE.bazel", "go.mod", "pnpm-lock.yaml", "unused.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	mockBazel.SetRepoMapping(map[string]string{
+		"":    "",
+		"npm": "aspect_rules_js+",
+	})
+	mockBazel.AddModResponse("show_repo @@aspect_rules_js+", `npm_translate_lock_rule(name = "npm", pnpm_lock = "@@//:pnpm-lock.yaml")`)
+	mockBazel.AddModResponse("graph --output=json --extension_info=usages", `{"key": "<root>", "extensionUsages": ["@@gazelle+//:extensions.bzl%go_deps"]}`)
+	mockBazel.AddModResponse("show_extension @@gazelle+//:extensions.bzl%go_deps", `go_deps.from_file(go_mod = "//:go.mod")`)
+
+	assertEqual(t, []string{
+		filepath.Join(dir, "MODULE.bazel"),
+		filepath.Join(dir, "go.mod"),
+		filepath.Join(dir, "pnpm-lock.yaml"),
+	}, i.findRepositoryInputs(dir), "Repository inputs")
+}
+
+func TestIBazel_findRepositoryInputsFallback(t *testing.T) {
+	log.SetTesting(t)
+
+	i, _ := newIBazel(t)
+	defer i.Cleanup()
+
+	// Without bazel mod output, the module file is scanned for labels.
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	module := filepath.Join(dir, "MODULE.bazel")
+	goMod := filepath.Join(dir, "go.mod")
+	if err := os.WriteFile(module, []byte(`go_deps.from_file(go_mod = "//:go.mod")`), 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", module, err)
+	}
+	if err := os.WriteFile(goMod, nil, 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", goMod, err)
+	}
+	assertEqual(t, []string{module, goMod}, i.findRepositoryInputs(dir), "Repository inputs")
+}
+
+func TestIBazel_labelsToWatch(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	workspacePath := filepath.Join(t.TempDir(), "main")
+	outputBase := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputBase, "external"), 0o700); err != nil {
+		t.Fatalf("failed to create external directory: %v", err)
+	}
+	if err := os.MkdirAll(workspacePath, 0o700); err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	module := `local_path_override(module_name = "lib", path = "../lib")`
+	if err := os.WriteFile(filepath.Join(workspacePath, "MODULE.bazel"), []byte(module), 0o600); err != nil {
+		t.Fatalf("failed to create MODULE.bazel: %v", err)
+	}
+	mockBazel.SetInfo(map[string]string{
+		"output_base":  outputBase,
+		"install_base": t.TempDir(),
+	})
+	mockBazel.SetRepoMapping(map[string]string{
+		"mylib": "lib~",
+		"tools": "tools~",
+	})
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: workspacePath}
+	i.SetBazelArgs([]string{"--override_module=tools=%workspace%/../tools"})
+
+	got, err := i.labelsToWatch([]string{
+		"//pkg:a.go",
+		"@@//pkg:b.go",
+		"@mylib//:lib.go",
+		"@@lib~//sub:more.go",
+		"@tools//:tool.sh",
+		"@remote//:remote.go",
+		"//pkg:c.go",
+	})
+	if err != nil {
+		t.Fatalf("labelsToWatch() = %v", err)
+	}
+	root := filepath.Dir(workspacePath)
+	assertEqual(t, []string{
+		filepath.Join(workspacePath, "pkg", "a.go"),
+		filepath.Join(workspacePath, "pkg", "b.go"),
+		filepath.Join(root, "lib", "lib.go"),
+		filepath.Join(root, "lib", "sub", "more.go"),
+		filepath.Join(root, "tools", "tool.sh"),
+		filepath.Join(workspacePath, "pkg", "c.go"),
+	}, got, "Files to watch")
+}
+
+func TestIBazel_repositoryOverridesFromBazel(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	workspacePath := filepath.Join(t.TempDir(), "main")
+	mockBazel.SetRepoMapping(map[string]string{
+		"mylib": "lib+",
+	})
+	// Bazel prints the local_path_override of MODULE.bazel as a local
+	// repository, whatever the formatting of the override.
+	mockBazel.AddModResponse("show_repo @@lib+", `## @mylib:
+# <builtin>
+local_repository(
+  name = "lib+",
+  path = "../lib (fork)",
+)
+`)
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: workspacePath}
+
+	assertEqual(t, map[string]string{
+		"lib+": filepath.Join(filepath.Dir(workspacePath), "lib (fork)"),
+	}, i.repositoryOverrides(), "Repository overrides")
+}
+
+func TestIBazel_labelsToWatchFilter(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	workspacePath := t.TempDir()
+	outputBase := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputBase, "external"), 0o700); err != nil {
+		t.Fatalf("failed to create external directory: %v", err)
+	}
+	mockBazel.SetInfo(map[string]string{
+		"output_base":  outputBase,
+		"install_base": t.TempDir(),
+	})
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: workspacePath}
+	if err := i.SetWatchFilter([]string{"//src/...,//lib/..."}, []string{"//src/vendor/..."}); err != nil {
+		t.Fatalf("SetWatchFilter() = %v", err)
+	}
+
+	got, err := i.labelsToWatch([]string{
+		"//src:main.go",
+		"//src/vendor/dep:dep.go",
+		"//lib/util:util.go",
+		"//third_party/big:big.go",
+	})
+	if err != nil {
+		t.Fatalf("labelsToWatch() = %v", err)
+	}
+	assertEqual(t, []string{
+		filepath.Join(workspacePath, "src", "main.go"),
+		filepath.Join(workspacePath, "lib", "util", "util.go"),
+	}, got, "Files to watch")
+
+	if err := i.SetWatchFilter([]string{"src/..."}, nil); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
+
+func TestIBazel_aqueryForSourceFiles(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	workspacePath := t.TempDir()
+	outputBase := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputBase, "external"), 0o700); err != nil {
+		t.Fatalf("failed to create external directory: %v", err)
+	}
+	mockBazel.SetInfo(map[string]string{
+		"output_base":  outputBase,
+		"install_base": t.TempDir(),
+	})
+	mockBazel.AddAQueryInputsResponse("deps(set(//my:target))", []string{
+		"bazel-out/k8-fastbuild/bin/my/gen.go",
+		"external/lib/lib.go",
+		"external/remote/remote.go",
+		"my/main.go",
+	})
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: workspacePath}
+	i.SetBazelArgs([]string{"--override_repository=lib=/src/lib"})
+	i.SetWatchDiscovery("aquery")
+
+	got, err := i.queryForSourceFiles("//my:target")
+	if err != nil {
+		t.Fatalf("queryForSourceFiles() = %v", err)
+	}
+	assertEqual(t, []string{
+		filepath.Join("/src/lib", "lib.go"),
+		filepath.Join(workspacePath, "my", "main.go"),
+	}, got, "Files to watch")
+}
+
+func TestIBazel_aqueryForSourceFilesFilter(t *testing.T) {
+	log.SetTesting(t)
+
+	i, mockBazel := newIBazel(t)
+	defer i.Cleanup()
+
+	workspacePath := t.TempDir()
+	outputBase := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputBase, "external"), 0o700); err != nil {
+		t.Fatalf("failed to create external directory: %v", err)
+	}
+	mockBazel.SetInfo(map[string]string{
+		"output_base":  outputBase,
+		"install_base": t.TempDir(),
+	})
+	for _, pkg := range []string{"src", "src/vendor/dep", "third_party"} {
+		dir := filepath.Join(workspacePath, filepath.FromSlash(pkg))
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatalf("failed to create %s: %v", pkg, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), nil, 0o600); err != nil {
+			t.Fatalf("failed to create the BUILD file of %s: %v", pkg, err)
+		}
+	}
+	mockBazel.AddAQueryInputsResponse("deps(set(//src:main))", []string{
+		"src/main.go",
+		"src/internal/helper.go",
+		"src/vendor/dep/dep.go",
+		"third_party/big.go",
+	})
+	i.workspaceFinder = &workspace.FakeWorkspace{Path: workspacePath}
+	i.SetWatchDiscovery("aquery")
+	if err := i.SetWatchFilter([]string{"//src:all"}, []string{"//src:main.go"}); err != nil {
+		t.Fatalf("SetWatchFilter() = %v", err)
+	}
+
+	got, err := i.queryForSourceFiles("//src:main")
+	if err != nil {
+		t.Fatalf("queryForSourceFiles() = %v", err)
+	}
+	// src/internal has no BUILD file, so helper.go belongs to //src.
+	assertEqual(t, []string{
+		filepath.Join(workspacePath, "src", "internal", "helper.go"),
+	}, got, "Files to watch")
+}
@@ -22,10 +22,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 )
 
+// forwardedSignals are passed on to the running process instead of being
+// handled by iBazel.
+var forwardedSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGQUIT}
+
 func (i *IBazel) realLocalRepositoryPaths() (map[string]string, error) {
 	info, _, err := i.getInfo()
 	if err != nil {
@@ -59,35 +64,10 @@ func (i *IBazel) realLocalRepositoryPaths() (map[string]string, error) {
 		}
 	}
 
-	// Replace the canonical repository name with the actual repository name
-	// from the repository mapping known to Bazel.
-	if len(localRepositories) > 0 {
-		if repoMapping, _, err := i.dumpRootRepoMapping(); err == nil { // if NO error
-			for repo, canonical := range repoMapping {
-				if realPath, ok := localRepositories[canonical]; ok {
-					delete(localRepositories, canonical)
-					localRepositories[repo] = realPath
-				}
-			}
-		} else {
-			// Don't fail on this. We will try without the mapping.
-			log.Errorf("Error finding repository mapping: %v\n", err)
-		}
-
-	}
-
-	// Apply overrides set via arguments. Overrides must already be absolute.
-	// https://docs.bazel.build/versions/master/external.html#overriding-repositories-from-the-command-line
-	for _, arg := range i.bazelArgs {
-		if strings.HasPrefix(arg, "--override_repository") {
-			parts := strings.Split(arg, "=")
-			if len(parts) != 3 {
-				log.Errorf("ibazel cannot parse argument: %v", arg)
-				continue
-			}
-			localRepositories[parts[1]] = parts[2]
-		}
+	for repo, path := range i.repositoryOverrides() {
+		localRepositories[repo] = path
 	}
+	i.addRepositoryAliases(localRepositories)
 
 	return localRepositories, nil
 }
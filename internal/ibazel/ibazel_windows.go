@@ -15,20 +15,29 @@
 package ibazel
 
 import (
+	"os"
+
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 )
 
+// forwardedSignals are passed on to the running process instead of being
+// handled by iBazel. Windows doesn't have any signals worth forwarding.
+var forwardedSignals []os.Signal
+
 var alreadyNotifiedOfLocalRepositories bool
 
 func (i *IBazel) realLocalRepositoryPaths() (map[string]string, error) {
 	if !alreadyNotifiedOfLocalRepositories {
 		// Put the entire implementation of this method in here
 		log.Banner(
-			"iBazel does not support watching local_repository or --override_repository",
+			"iBazel does not support watching local_repository",
 			"If this is a feature you'd like to add support for, please visit",
 			"https://github.com/bazelbuild/bazel-watcher/issues/274",
 		)
 	}
 	alreadyNotifiedOfLocalRepositories = true
-	return map[string]string{}, nil
+
+	localRepositories := i.repositoryOverrides()
+	i.addRepositoryAliases(localRepositories)
+	return localRepositories, nil
 }
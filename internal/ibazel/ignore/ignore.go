@@ -0,0 +1,243 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ignore decides which files not to watch, using the syntax of
+// .gitignore files.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// File is the file in the workspace root that lists the files not to watch.
+const File = ".ibazelignore"
+
+type pattern struct {
+	expr    *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored patterns match paths relative to the root, the others match
+	// file and directory names at any level.
+	anchored bool
+}
+
+// Matcher matches paths against ignore patterns.
+type Matcher struct {
+	root     string
+	patterns []pattern
+}
+
+// New returns a Matcher for patterns relative to root. Invalid patterns are
+// left out and reported in the error, along with the valid ones in the
+// Matcher.
+func New(root string, patterns ...string) (*Matcher, error) {
+	m := &Matcher{root: root}
+	var errs []error
+	for _, p := range patterns {
+		if err := m.add(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return m, errors.Join(errs...)
+}
+
+// Load returns a Matcher for the File in root, if there is one, followed by
+// patterns. Later patterns take precedence, so patterns can override the file.
+// Invalid patterns are left out and reported in the error, along with the
+// Matcher. The Matcher is nil if the File can't be read.
+func Load(root string, patterns ...string) (*Matcher, error) {
+	m := &Matcher{root: root}
+	var errs []error
+	f, err := os.Open(filepath.Join(root, File))
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			if err := m.add(scanner.Text()); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %w", File, n, err))
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, p := range patterns {
+		if err := m.add(p); err != nil {
+			errs = append(errs, fmt.Errorf("--ignore: %w", err))
+		}
+	}
+	return m, errors.Join(errs...)
+}
+
+func (m *Matcher) add(line string) error {
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	original := line
+
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	expr, err := regexp.Compile("^" + globExpr(line) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", original, err)
+	}
+	p.expr = expr
+	m.patterns = append(m.patterns, p)
+	return nil
+}
+
+// trimTrailingSpaces removes trailing spaces that aren't escaped.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return strings.ReplaceAll(line, `\ `, " ")
+}
+
+// globExpr translates a gitignore glob into a regular expression.
+func globExpr(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				expr.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			negate := ""
+			// Like git, accept both [!...] and [^...].
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				negate, class = "^", class[1:]
+			}
+			expr.WriteString("[" + negate + classExpr(class) + "]")
+			i += end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// classExpr translates the inside of a bracket expression, keeping ranges
+// such as a-z.
+func classExpr(class string) string {
+	var expr strings.Builder
+	for _, c := range class {
+		switch c {
+		case '\\', '[', '^':
+			expr.WriteByte('\\')
+		}
+		expr.WriteRune(c)
+	}
+	return expr.String()
+}
+
+// Empty reports whether m has no patterns.
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// Ignored reports whether the file at path is ignored, either by itself or
+// because one of its parent directories is. Paths outside of the root are only
+// matched by patterns without a slash.
+func (m *Matcher) Ignored(path string) bool {
+	if m.Empty() {
+		return false
+	}
+
+	rel := path
+	inRoot := false
+	if r, err := filepath.Rel(m.root, path); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		rel = r
+		inRoot = true
+	}
+	parts := strings.Split(strings.TrimPrefix(filepath.ToSlash(rel), "/"), "/")
+
+	// As in git, a file can't be included again once a parent directory is
+	// ignored.
+	for i := 1; i <= len(parts); i++ {
+		if m.match(parts[:i], i < len(parts), inRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the patterns in order to the path made of parts. The last
+// pattern that matches wins.
+func (m *Matcher) match(parts []string, isDir bool, inRoot bool) bool {
+	ignored := false
+	name := parts[len(parts)-1]
+	path := strings.Join(parts, "/")
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.anchored {
+			if !inRoot || !p.expr.MatchString(path) {
+				continue
+			}
+		} else if !p.expr.MatchString(name) {
+			continue
+		}
+		ignored = !p.negate
+	}
+	return ignored
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	root := filepath.FromSlash("/ws")
+	m, err := New(root,
+		"# Generated code",
+		"*.pb.go",
+		"!keep.pb.go",
+		"/package-lock.json",
+		"node_modules/",
+		"docs/**/*.md",
+		"build/",
+		"!build/important.txt",
+		`\#notes`,
+		"file[0-9].txt",
+		"[a-c]*.log",
+		"x[^y].txt",
+	)
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+
+	for path, want := range map[string]bool{
+		"/ws/api/service.pb.go":           true,
+		"/ws/api/keep.pb.go":              false,
+		"/ws/package-lock.json":           true,
+		"/ws/web/package-lock.json":       false,
+		"/ws/web/node_modules/x/index.js": true,
+		"/ws/node_modules":                false, // A file, not a directory.
+		"/ws/docs/a/b/guide.md":           true,
+		"/ws/docs/guide.md":               true,
+		"/ws/README.md":                   false,
+		"/ws/build/important.txt":         true, // The directory stays ignored.
+		"/ws/#notes":                      true,
+		"/ws/file1.txt":                   true,
+		"/ws/filex.txt":                   false,
+		"/ws/build.log":                   true,
+		"/ws/debug.log":                   false,
+		"/ws/xz.txt":                      true,
+		"/ws/xy.txt":                      false,
+		"/external/repo/gen.pb.go":        true,
+		"/external/package-lock.json":     false,
+	} {
+		if got := m.Ignored(filepath.FromSlash(path)); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	if m, err := Load(root); err != nil || !m.Empty() {
+		t.Errorf("Load() without %s = %v, %v, want an empty matcher", File, m, err)
+	}
+
+	os.WriteFile(filepath.Join(root, File), []byte("*.lock\n\n# comment\ngen/\n"), 0644)
+	m, err := Load(root, "!yarn.lock")
+	if err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	for path, want := range map[string]bool{
+		"Cargo.lock":  true,
+		"yarn.lock":   false,
+		"gen/out.txt": true,
+		"src/main.go": false,
+	} {
+		if got := m.Ignored(filepath.Join(root, path)); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, File), []byte("*.tmp\nfoo[]\n"), 0644)
+
+	m, err := Load(root, "[!]", "*.bak")
+	if err == nil {
+		t.Fatalf("Load() should report the invalid patterns")
+	}
+	for _, want := range []string{File + ":2:", "foo[]", "--ignore", "[!]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error %q doesn't mention %q", err, want)
+		}
+	}
+	// The valid patterns still apply.
+	for path, want := range map[string]bool{
+		"a.tmp": true,
+		"a.bak": true,
+		"a.go":  false,
+	} {
+		if got := m.Ignored(filepath.Join(root, path)); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package ibazel
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
 )
@@ -18,7 +19,7 @@ type Lifecycle interface {
 	TargetDecider(rule *blaze_query.Rule)
 
 	// ChangeDetected is called when a change is detected
-	// changeType: "source"|"graph"
+	// changeType: "source"|"graph"|"env"|"extra"|"config"
 	ChangeDetected(targets []string, changeType string, change string)
 
 	// Cleanup is your opportunity to clean up open sockets or connections.
@@ -32,4 +33,10 @@ type Lifecycle interface {
 	// that command.
 	// command: "build"|"test"|"run"
 	AfterCommand(targets []string, command string, success bool, output *bytes.Buffer)
+
+	// ProcessExited is called when the process started by `run` exits.
+	// signal: the name of the signal that killed the process, if any
+	// duration: how long the process ran for
+	// requested: whether iBazel stopped the process, such as to restart it
+	ProcessExited(target string, exitCode int, signal string, duration time.Duration, requested bool)
 }
@@ -19,6 +19,7 @@ package lifecycle_hooks
 import (
 	"bytes"
 	"flag"
+	"time"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/workspace"
@@ -64,6 +65,9 @@ func (l *LifecycleHooks) AfterCommand(targets []string, command string, success
 	}
 }
 
+func (l *LifecycleHooks) ProcessExited(target string, exitCode int, signal string, duration time.Duration, requested bool) {
+}
+
 func (l *LifecycleHooks) parseAndExecuteCommand(commandToRun string) {
 	if commandToRun != "" {
 		commandAndArgs, err := shellwords.Parse(commandToRun)
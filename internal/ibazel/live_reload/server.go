@@ -21,10 +21,12 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/jaschaephraim/lrserver"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/tags"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
 
 	golog "log"
@@ -56,18 +58,14 @@ func (l *LiveReloadServer) Cleanup() {
 }
 
 func (l *LiveReloadServer) TargetDecider(rule *blaze_query.Rule) {
-	for _, attr := range rule.Attribute {
-		if *attr.Name == "tags" && *attr.Type == blaze_query.Attribute_STRING_LIST {
-			if contains(attr.StringListValue, "ibazel_live_reload") {
-				if *noLiveReload {
-					log.Log("Target requests live_reload but liveReload has been disabled with the -nolive_reload flag.")
-					return
-				}
-				l.startLiveReloadServer()
-				return
-			}
-		}
+	if config, _ := tags.Parse(rule); !config.LiveReload {
+		return
 	}
+	if *noLiveReload {
+		log.Log("Target requests live_reload but liveReload has been disabled with the -nolive_reload flag.")
+		return
+	}
+	l.startLiveReloadServer()
 }
 
 func (l *LiveReloadServer) ChangeDetected(targets []string, changeType string, change string) {
@@ -79,6 +77,9 @@ func (l *LiveReloadServer) AfterCommand(targets []string, command string, succes
 	l.triggerReload(targets)
 }
 
+func (l *LiveReloadServer) ProcessExited(target string, exitCode int, signal string, duration time.Duration, requested bool) {
+}
+
 func (l *LiveReloadServer) ReloadTriggered(targets []string) {}
 
 func (l *LiveReloadServer) startLiveReloadServer() {
@@ -127,12 +128,3 @@ func testPort(port uint16) bool {
 	ln.Close()
 	return true
 }
-
-func contains(l []string, e string) bool {
-	for _, i := range l {
-		if i == e {
-			return true
-		}
-	}
-	return false
-}
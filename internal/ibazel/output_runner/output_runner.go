@@ -26,6 +26,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/internal/ibazel/workspace"
@@ -86,6 +87,9 @@ func (i *OutputRunner) AfterCommand(targets []string, command string, success bo
 	i.executeOutput(output)
 }
 
+func (i *OutputRunner) ProcessExited(target string, exitCode int, signal string, duration time.Duration, requested bool) {
+}
+
 func (i *OutputRunner) executeOutput(output *bytes.Buffer) {
 	jsonCommandPath := ".bazel_fix_commands.json"
 	defaultRegex := Optcmd{
@@ -0,0 +1,59 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process_group
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CgroupLimits are the resources processes in a cgroup may use. Zero values
+// mean no limit.
+type CgroupLimits struct {
+	// MemoryBytes is the memory.max of the cgroup.
+	MemoryBytes int64
+	// CPUs is the number of CPUs worth of time the processes may use.
+	CPUs float64
+}
+
+func (l CgroupLimits) controllers() []string {
+	var c []string
+	if l.MemoryBytes > 0 {
+		c = append(c, "memory")
+	}
+	if l.CPUs > 0 {
+		c = append(c, "cpu")
+	}
+	return c
+}
+
+// ParseBytes parses a size in bytes with an optional K, M, G or T suffix,
+// which are powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
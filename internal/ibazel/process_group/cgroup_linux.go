@@ -0,0 +1,290 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process_group
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cgroupMounts are where the cgroup v2 hierarchy is mounted, with the unified
+// and the hybrid layout.
+var cgroupMounts = []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"}
+
+// cgroupProcessGroup is a unixProcessGroup whose processes all live in a
+// cgroup of their own. Processes that leave the process group, for example
+// by daemonizing, stay in the cgroup and are still terminated.
+type cgroupProcessGroup struct {
+	*unixProcessGroup
+	dir string
+}
+
+var cgroupParent struct {
+	sync.Mutex
+	dir string
+	n   int
+}
+
+// CgroupCommand creates a new ProcessGroup like Command, except that its
+// processes are put in a new cgroup v2 below the one of iBazel, which must be
+// writable. This is only supported on Linux.
+func CgroupCommand(limits CgroupLimits, name string, arg ...string) (ProcessGroup, error) {
+	dir, err := newCgroup(limits)
+	if err != nil {
+		return nil, err
+	}
+	return &cgroupProcessGroup{
+		unixProcessGroup: Command(name, arg...).(*unixProcessGroup),
+		dir:              dir,
+	}, nil
+}
+
+func newCgroup(limits CgroupLimits) (string, error) {
+	cgroupParent.Lock()
+	defer cgroupParent.Unlock()
+
+	if cgroupParent.dir == "" {
+		dir, err := ownCgroup()
+		if err != nil {
+			return "", err
+		}
+		cgroupParent.dir = dir
+	}
+	if controllers := limits.controllers(); len(controllers) > 0 {
+		if err := enableControllers(cgroupParent.dir, controllers); err != nil {
+			return "", err
+		}
+	}
+
+	cgroupParent.n++
+	dir := filepath.Join(cgroupParent.dir, fmt.Sprintf("ibazel-run-%d-%d", os.Getpid(), cgroupParent.n))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	if limits.MemoryBytes > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	if limits.CPUs > 0 {
+		if err := writeCgroupFile(dir, "cpu.max", cpuMax(limits.CPUs)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 iBazel is in.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	path, err := parseProcCgroup(f)
+	if err != nil {
+		return "", err
+	}
+	for _, mount := range cgroupMounts {
+		dir := filepath.Join(mount, path)
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", errors.New("no cgroup v2 hierarchy is mounted")
+}
+
+// parseProcCgroup returns the cgroup v2 path from the contents of
+// /proc/<pid>/cgroup.
+func parseProcCgroup(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("not running in a cgroup v2 hierarchy")
+}
+
+// enableControllers makes controllers available to the children of dir.
+// Processes may not live in a cgroup that has controllers enabled for its
+// children, so iBazel moves itself into a leaf cgroup of its own if needed.
+func enableControllers(dir string, controllers []string) error {
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	var enable []string
+	for _, c := range controllers {
+		if !containsField(string(available), c) {
+			return fmt.Errorf("the %s controller isn't delegated to %s", c, dir)
+		}
+		enable = append(enable, "+"+c)
+	}
+	value := strings.Join(enable, " ")
+
+	err = writeCgroupFile(dir, "cgroup.subtree_control", value)
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+	leaf := filepath.Join(dir, "ibazel")
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return err
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", value)
+}
+
+// cpuMax returns the value of cpu.max that allows using the given number of
+// CPUs, over the default period of 100ms.
+func cpuMax(cpus float64) string {
+	const period = 100000
+	return fmt.Sprintf("%d %d", int64(cpus*period), period)
+}
+
+func (pg *cgroupProcessGroup) prepare() (int, error) {
+	fd, err := syscall.Open(pg.dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if pg.root.SysProcAttr == nil {
+		pg.root.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	pg.root.SysProcAttr.UseCgroupFD = true
+	pg.root.SysProcAttr.CgroupFD = fd
+	return fd, nil
+}
+
+func (pg *cgroupProcessGroup) Start() error {
+	fd, err := pg.prepare()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return pg.unixProcessGroup.Start()
+}
+
+func (pg *cgroupProcessGroup) CombinedOutput() ([]byte, error) {
+	fd, err := pg.prepare()
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	return pg.unixProcessGroup.CombinedOutput()
+}
+
+// Signal sends signum to the process group and to every other process in the
+// cgroup.
+func (pg *cgroupProcessGroup) Signal(signum syscall.Signal) error {
+	if signum == syscall.SIGKILL {
+		// Available since Linux 5.14.
+		if err := writeCgroupFile(pg.dir, "cgroup.kill", "1"); err == nil {
+			return nil
+		}
+	}
+	err := pg.unixProcessGroup.Signal(signum)
+	pids, _ := pg.pids()
+	for _, pid := range pids {
+		if syscall.Kill(pid, signum) == nil {
+			err = nil
+		}
+	}
+	return err
+}
+
+// Close kills whatever is left in the cgroup and removes it.
+func (pg *cgroupProcessGroup) Close() error {
+	for deadline := time.Now().Add(time.Second); ; {
+		pids, err := pg.pids()
+		if err != nil || len(pids) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes %v are still running in %s", pids, pg.dir)
+		}
+		pg.Signal(syscall.SIGKILL)
+		time.Sleep(10 * time.Millisecond)
+	}
+	return os.Remove(pg.dir)
+}
+
+func (pg *cgroupProcessGroup) Usage() (Usage, error) {
+	var u Usage
+	stat, err := os.ReadFile(filepath.Join(pg.dir, "cpu.stat"))
+	if err != nil {
+		return u, err
+	}
+	u.CPUTime = parseCPUStat(stat)
+	// memory.peak only exists if the memory controller is enabled.
+	if peak, err := os.ReadFile(filepath.Join(pg.dir, "memory.peak")); err == nil {
+		u.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	return u, nil
+}
+
+func parseCPUStat(stat []byte) time.Duration {
+	for _, line := range bytes.Split(stat, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, _ := strconv.ParseInt(fields[1], 10, 64)
+			return time.Duration(usec) * time.Microsecond
+		}
+	}
+	return 0
+}
+
+func (pg *cgroupProcessGroup) pids() ([]int, error) {
+	procs, err := os.ReadFile(filepath.Join(pg.dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
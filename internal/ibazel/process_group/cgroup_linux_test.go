@@ -0,0 +1,97 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process_group
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcCgroup(t *testing.T) {
+	got, err := parseProcCgroup(strings.NewReader("4:memory:/user.slice\n0::/user.slice/user-1000.slice/session-1.scope\n"))
+	if err != nil {
+		t.Fatalf("parseProcCgroup(): %v", err)
+	}
+	if want := "/user.slice/user-1000.slice/session-1.scope"; got != want {
+		t.Errorf("parseProcCgroup() = %q, want %q", got, want)
+	}
+
+	if _, err := parseProcCgroup(strings.NewReader("4:memory:/user.slice\n")); err == nil {
+		t.Errorf("parseProcCgroup() should fail without a cgroup v2 entry")
+	}
+}
+
+func TestParseCPUStat(t *testing.T) {
+	got := parseCPUStat([]byte("usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"))
+	if want := 1500 * time.Millisecond; got != want {
+		t.Errorf("parseCPUStat() = %s, want %s", got, want)
+	}
+}
+
+func TestCPUMax(t *testing.T) {
+	if got, want := cpuMax(1.5), "150000 100000"; got != want {
+		t.Errorf("cpuMax(1.5) = %q, want %q", got, want)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{
+		"100":   100,
+		"512M":  512 << 20,
+		"512mb": 512 << 20,
+		"2G":    2 << 30,
+		"1k":    1024,
+	} {
+		got, err := ParseBytes(in)
+		if err != nil || got != want {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "lots", "-1", "1.5G"} {
+		if _, err := ParseBytes(in); err == nil {
+			t.Errorf("ParseBytes(%q) should fail", in)
+		}
+	}
+}
+
+func TestCgroupCommand(t *testing.T) {
+	pg, err := CgroupCommand(CgroupLimits{}, "sh", "-c", "setsid sleep 10 & exit 0")
+	if err != nil {
+		t.Skipf("No writable cgroup: %v", err)
+	}
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	pg.Wait()
+
+	// The daemonized sleep left the process group but not the cgroup.
+	cg := pg.(*cgroupProcessGroup)
+	if pids, _ := cg.pids(); len(pids) == 0 {
+		t.Errorf("The daemonized process isn't in the cgroup")
+	}
+	if _, err := cg.Usage(); err != nil {
+		t.Errorf("Usage(): %v", err)
+	}
+	pg.Signal(syscall.SIGKILL)
+	if err := pg.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+	if _, err := os.Stat(cg.dir); !os.IsNotExist(err) {
+		t.Errorf("Close() didn't remove %s", cg.dir)
+	}
+}
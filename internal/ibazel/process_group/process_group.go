@@ -28,6 +28,7 @@ package process_group
 import (
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 // ProcessGroup represents a tree of processes that can be terminated
@@ -40,3 +41,17 @@ type ProcessGroup interface {
 	Close() error
 	CombinedOutput() ([]byte, error)
 }
+
+// Usage is the resources used by a process group.
+type Usage struct {
+	// MemoryPeak is the highest memory usage in bytes, or 0 if unknown.
+	MemoryPeak uint64
+	// CPUTime is the CPU time used by all processes.
+	CPUTime time.Duration
+}
+
+// UsageReporter is implemented by process groups that can tell how many
+// resources their processes used.
+type UsageReporter interface {
+	Usage() (Usage, error)
+}
@@ -17,12 +17,16 @@
 package process_group
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
 
 type unixProcessGroup struct {
 	root *exec.Cmd
+	// tty is the terminal side of a pseudo-terminal attached to root. It is
+	// only needed by the child, so it is closed once root has started.
+	tty *os.File
 }
 
 // Command creates a new ProcessGroup with a root command specified by the
@@ -30,7 +34,7 @@ type unixProcessGroup struct {
 func Command(name string, arg ...string) ProcessGroup {
 	root := exec.Command(name, arg...)
 	root.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	return &unixProcessGroup{root}
+	return &unixProcessGroup{root: root}
 }
 
 func (pg *unixProcessGroup) RootProcess() *exec.Cmd {
@@ -38,7 +42,12 @@ func (pg *unixProcessGroup) RootProcess() *exec.Cmd {
 }
 
 func (pg *unixProcessGroup) Start() error {
-	return pg.root.Start()
+	err := pg.root.Start()
+	if pg.tty != nil {
+		pg.tty.Close()
+		pg.tty = nil
+	}
+	return err
 }
 
 func (pg *unixProcessGroup) Signal(signum syscall.Signal) error {
@@ -0,0 +1,105 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process_group
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// AttachTerminal runs the root process of pg on a new pseudo-terminal, which
+// becomes its controlling terminal, its stdout and stderr and, unless another
+// stdin was set, its stdin. It returns the controlling side of the
+// pseudo-terminal, which the caller is responsible for closing. It must be
+// called before Start.
+func AttachTerminal(pg ProcessGroup) (*os.File, error) {
+	var upg *unixProcessGroup
+	switch pg := pg.(type) {
+	case *unixProcessGroup:
+		upg = pg
+	case *cgroupProcessGroup:
+		upg = pg.unixProcessGroup
+	default:
+		return nil, errors.New("unsupported process group")
+	}
+
+	pty, tty, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	root := upg.root
+	if root.Stdin == nil {
+		root.Stdin = tty
+	}
+	root.Stdout = tty
+	root.Stderr = tty
+	// A new session is also a new process group, so Signal keeps reaching every
+	// process. Ctty is the stdout of the child.
+	root.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+	upg.tty = tty
+	return pty, nil
+}
+
+// InheritWindowSize gives pty the window size of the terminal from.
+func InheritWindowSize(pty *os.File, from *os.File) error {
+	ws, err := unix.IoctlGetWinsize(int(from.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+func openPTY() (pty *os.File, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			pty.Close()
+		}
+	}()
+
+	fd := int(pty.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return nil, nil, fmt.Errorf("unlocking pseudo-terminal: %v", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting pseudo-terminal number: %v", err)
+	}
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The terminal of iBazel already echoes what is typed, so the
+	// pseudo-terminal mustn't echo it a second time.
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Lflag &^= unix.ECHO
+		err = unix.IoctlSetTermios(int(tty.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, nil, fmt.Errorf("configuring pseudo-terminal: %v", err)
+	}
+	return pty, tty, nil
+}
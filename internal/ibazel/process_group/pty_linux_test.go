@@ -0,0 +1,43 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process_group
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAttachTerminal(t *testing.T) {
+	pg := Command("sh", "-c", "test -t 0 && test -t 1 && test -t 2 && echo on a terminal")
+	pty, err := AttachTerminal(pg)
+	if err != nil {
+		t.Fatalf("AttachTerminal(): %v", err)
+	}
+	defer pty.Close()
+
+	if err := pg.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	// Reading fails with EIO once the process is gone, after returning
+	// everything it wrote.
+	out, _ := io.ReadAll(pty)
+	if err := pg.Wait(); err != nil {
+		t.Errorf("Wait(): %v", err)
+	}
+	if !strings.Contains(string(out), "on a terminal") {
+		t.Errorf("The process didn't run on a terminal. Output: %q", out)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package process_group
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoPTY = errors.New("pseudo-terminals are only supported on Linux")
+
+// AttachTerminal is only supported on Linux.
+func AttachTerminal(pg ProcessGroup) (*os.File, error) {
+	return nil, errNoPTY
+}
+
+// InheritWindowSize is only supported on Linux.
+func InheritWindowSize(pty *os.File, from *os.File) error {
+	return errNoPTY
+}
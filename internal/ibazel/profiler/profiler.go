@@ -80,6 +80,12 @@ type profileEvent struct {
 	// build & reload event
 	Changes []string `json:"changes,omitempty"`
 
+	// process exited event
+	ExitCode  *int   `json:"exitCode,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	Duration  int64  `json:"duration,omitempty"`
+	Requested bool   `json:"requested,omitempty"`
+
 	// browser event
 	RemoteType    string `json:"remoteType,omitempty"`
 	RemoteTime    int64  `json:"remoteTime,omitempty"`
@@ -132,6 +138,12 @@ func (i *Profiler) ChangeDetected(targets []string, changeType string, change st
 		i.changeEvent("SOURCE_CHANGE", change)
 	case "graph":
 		i.changeEvent("GRAPH_CHANGE", change)
+	case "env":
+		i.changeEvent("ENV_CHANGE", change)
+	case "extra":
+		i.changeEvent("EXTRA_CHANGE", change)
+	case "config":
+		i.changeEvent("CONFIG_CHANGE", change)
 	}
 }
 
@@ -176,6 +188,13 @@ func (i *Profiler) AfterCommand(targets []string, command string, success bool,
 	}
 }
 
+func (i *Profiler) ProcessExited(target string, exitCode int, signal string, duration time.Duration, requested bool) {
+	if i.file == nil {
+		return
+	}
+	i.processExitedEvent(exitCode, signal, duration, requested)
+}
+
 func (i *Profiler) Cleanup() {
 	if i.file != nil {
 		i.file.Close()
@@ -288,6 +307,18 @@ func (i *Profiler) reloadTriggeredEvent() {
 	i.lock.Unlock()
 }
 
+func (i *Profiler) processExitedEvent(exitCode int, signal string, duration time.Duration, requested bool) {
+	i.lock.Lock()
+	event := profileEvent{}
+	event.Type = "PROCESS_EXITED"
+	event.ExitCode = &exitCode
+	event.Signal = signal
+	event.Duration = duration.Milliseconds()
+	event.Requested = requested
+	i.processEvent(&event)
+	i.lock.Unlock()
+}
+
 func (i *Profiler) remoteEvent(remoteEvent *profilerRemoteEvent) {
 	i.lock.Lock()
 	if !i.iterationReloadTriggered {
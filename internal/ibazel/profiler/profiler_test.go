@@ -0,0 +1,66 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/internal/ibazel/log"
+)
+
+func TestProcessExited(t *testing.T) {
+	log.SetTesting(t)
+
+	path := filepath.Join(t.TempDir(), "profile.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New("test")
+	p.file = f
+	p.ProcessExited("//my:target", 0, "", 1500*time.Millisecond, false)
+	p.ProcessExited("//my:target", -1, "killed", time.Second, false)
+	p.ProcessExited("//my:target", 0, "", 2*time.Second, true)
+	f.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for _, want := range []struct {
+		exitCode  int
+		signal    string
+		duration  int64
+		requested bool
+	}{
+		{0, "", 1500, false},
+		{-1, "killed", 1000, false},
+		{0, "", 2000, true},
+	} {
+		var event profileEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("Decoding event: %v", err)
+		}
+		if event.Type != "PROCESS_EXITED" || event.ExitCode == nil || *event.ExitCode != want.exitCode || event.Signal != want.signal || event.Duration != want.duration || event.Requested != want.requested {
+			t.Errorf("Got event %+v, want exit code %d, signal %q, duration %d and requested %v", event, want.exitCode, want.signal, want.duration, want.requested)
+		}
+	}
+}
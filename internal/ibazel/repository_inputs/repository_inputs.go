@@ -0,0 +1,242 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package repository_inputs finds the files that external repositories are
+// fetched from, such as MODULE.bazel and the go.mod or lockfiles read by
+// repository rules and module extensions. Bazel doesn't report them as build
+// files, so they are found in the attributes of repositories and in the tags
+// of module extensions that Bazel prints, or, when it can't, by following the
+// labels in the workspace's module and WORKSPACE files.
+package repository_inputs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Manifests are the files in the workspace root that declare external
+// repositories. MODULE.bazel.lock is left out as Bazel writes it during builds.
+var Manifests = []string{
+	"MODULE.bazel",
+	"WORKSPACE",
+	"WORKSPACE.bazel",
+	"WORKSPACE.bzlmod",
+}
+
+// mainRepoLabel matches string literals holding a label in the main
+// repository, such as "//:go.mod", "@//third_party:requirements_lock.txt" or
+// "@@//:package.json".
+var mainRepoLabel = regexp.MustCompile(`"(?:@@?)?//([^":]*)(?::([^"]*))?"`)
+
+// Files returns the manifests in the workspace, with the module files they
+// include, and the files of the main repository named by labels.
+func Files(workspace string, labels []string) []string {
+	found := map[string]struct{}{}
+	var queue []string
+	for _, name := range Manifests {
+		queue = append(queue, filepath.Join(workspace, name))
+	}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if _, ok := found[file]; ok {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		found[file] = struct{}{}
+		for _, m := range include.FindAllStringSubmatch(string(content), -1) {
+			queue = append(queue, labelPaths(workspace, m[1])...)
+		}
+	}
+
+	for _, label := range labels {
+		for _, path := range labelPaths(workspace, `"`+label+`"`) {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				found[path] = struct{}{}
+			}
+		}
+	}
+	return sorted(found)
+}
+
+// include matches the include calls of module files, whose argument Bazel
+// requires to be a string literal.
+var include = regexp.MustCompile(`\binclude\s*\(\s*("[^"]*")`)
+
+// Labels returns the main repository labels quoted in the output of Bazel, such
+// as the attributes of a repository printed by bazel mod show_repo.
+func Labels(output string) []string {
+	var labels []string
+	for _, m := range mainRepoLabel.FindAllString(output, -1) {
+		labels = append(labels, strings.Trim(m, `"`))
+	}
+	return labels
+}
+
+// extensionID matches the id of a module extension, such as
+// @@gazelle+//:extensions.bzl%go_deps.
+var extensionID = regexp.MustCompile(`^@@?[^/@]*//[^%]*%[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ExtensionIDs returns the module extensions used in graph, the JSON output
+// of bazel mod graph --extension_info=usages.
+func ExtensionIDs(graph []byte) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(graph, &v); err != nil {
+		return nil, err
+	}
+	ids := map[string]struct{}{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if extensionID.MatchString(v) {
+				ids[v] = struct{}{}
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return sorted(ids), nil
+}
+
+// Find returns the manifests in the workspace and the files they reference by
+// label, following the Starlark files they load or include. It scans the files
+// for labels, for when Bazel can't print the repositories.
+func Find(workspace string) []string {
+	found := map[string]struct{}{}
+	var queue []string
+	for _, name := range Manifests {
+		queue = append(queue, filepath.Join(workspace, name))
+	}
+
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if _, ok := found[file]; ok {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		found[file] = struct{}{}
+		if !isStarlark(file) {
+			continue
+		}
+
+		for _, path := range labelPaths(workspace, string(content)) {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				queue = append(queue, path)
+			}
+		}
+	}
+
+	return sorted(found)
+}
+
+func sorted(set map[string]struct{}) []string {
+	files := make([]string, 0, len(set))
+	for file := range set {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// LocalPathOverrides returns the absolute paths of the modules overridden with
+// local_path_override in MODULE.bazel and the files it includes, by module
+// name.
+func LocalPathOverrides(workspace string) map[string]string {
+	overrides := map[string]string{}
+	for _, file := range Find(workspace) {
+		if base := filepath.Base(file); base != "MODULE.bazel" && !strings.HasSuffix(base, ".MODULE.bazel") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, args := range calls(string(content), "local_path_override") {
+			if args["module_name"] != "" && args["path"] != "" {
+				overrides[args["module_name"]] = localPath(workspace, args["path"])
+			}
+		}
+	}
+	return overrides
+}
+
+// LocalRepositories returns the absolute paths of the local_repository
+// definitions in the output of bazel mod show_repo, such as the ones of
+// local_path_override, by canonical repository name.
+func LocalRepositories(workspace, repos string) map[string]string {
+	paths := map[string]string{}
+	for _, args := range calls(repos, "local_repository") {
+		if args["name"] != "" && args["path"] != "" {
+			paths[args["name"]] = localPath(workspace, args["path"])
+		}
+	}
+	return paths
+}
+
+// localPath resolves the path of a local module or repository, which is
+// relative to the main workspace, even in included files.
+func localPath(workspace, path string) string {
+	dir := filepath.FromSlash(path)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// labelPaths returns the paths in workspace of the main repository labels in
+// content.
+func labelPaths(workspace, content string) []string {
+	var paths []string
+	for _, m := range mainRepoLabel.FindAllStringSubmatch(content, -1) {
+		pkg, name := m[1], m[2]
+		if name == "" {
+			name = filepath.Base(pkg)
+		}
+		if pkg == "" && name == "." {
+			continue
+		}
+		paths = append(paths, filepath.Join(workspace, filepath.FromSlash(pkg), filepath.FromSlash(name)))
+	}
+	return paths
+}
+
+// isStarlark reports whether file may reference other files by label.
+func isStarlark(file string) bool {
+	base := filepath.Base(file)
+	for _, name := range Manifests {
+		if base == name {
+			return true
+		}
+	}
+	return strings.HasSuffix(base, ".bzl") || strings.HasSuffix(base, ".bazel")
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository_inputs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	ws := t.TempDir()
+	write(t, filepath.Join(ws, "MODULE.bazel"), `
+module(name = "example")
+
+bazel_dep(name = "gazelle", version = "0.35.0")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.from_file(go_mod = "//:go.mod")
+
+pip = use_extension("@rules_python//python/extensions:pip.bzl", "pip")
+pip.parse(requirements_lock = "@@//third_party/python:requirements_lock.txt")
+
+include("//third_party:js.MODULE.bazel")
+`)
+	write(t, filepath.Join(ws, "third_party", "js.MODULE.bazel"), `
+npm.npm_translate_lock(pnpm_lock = "//:pnpm-lock.yaml")
+`)
+	write(t, filepath.Join(ws, "go.mod"), `module example.com/example`)
+	write(t, filepath.Join(ws, "third_party", "python", "requirements_lock.txt"), ``)
+	write(t, filepath.Join(ws, "pnpm-lock.yaml"), ``)
+	write(t, filepath.Join(ws, "MODULE.bazel.lock"), ``)
+	write(t, filepath.Join(ws, "WORKSPACE"), `
+load("@//tools:deps.bzl", "deps")
+deps()
+`)
+	write(t, filepath.Join(ws, "tools", "deps.bzl"), `
+def deps():
+    http_archive(name = "x", patches = ["//tools:x.patch"])
+    local_target(name = "y", target = "//tools:not_a_file")
+`)
+	write(t, filepath.Join(ws, "tools", "x.patch"), ``)
+
+	want := []string{
+		filepath.Join(ws, "MODULE.bazel"),
+		filepath.Join(ws, "WORKSPACE"),
+		filepath.Join(ws, "go.mod"),
+		filepath.Join(ws, "pnpm-lock.yaml"),
+		filepath.Join(ws, "third_party", "js.MODULE.bazel"),
+		filepath.Join(ws, "third_party", "python", "requirements_lock.txt"),
+		filepath.Join(ws, "tools", "deps.bzl"),
+		filepath.Join(ws, "tools", "x.patch"),
+	}
+	if got := Find(ws); !reflect.DeepEqual(want, got) {
+		t.Errorf("Find() = %v, want %v", got, want)
+	}
+}
+
+func TestFind_noManifests(t *testing.T) {
+	if got := Find(t.TempDir()); len(got) != 0 {
+		t.Errorf("Find() = %v, want none", got)
+	}
+}
+
+func TestLocalPathOverrides(t *testing.T) {
+	ws := t.TempDir()
+	write(t, filepath.Join(ws, "MODULE.bazel"), `
+bazel_dep(name = "lib", version = "1.0")
+local_path_override(
+    module_name = "lib",
+    path = "../lib",
+)
+local_path_override(module_name = "tools", path = "/opt/tools")
+include("//deps:dev.MODULE.bazel")
+local_path_override(
+    # Checked out next to this repository (see the README).
+    module_name = "fork",
+    path = "third_party/fork (patched)",
+)
+`)
+	write(t, filepath.Join(ws, "deps", "dev.MODULE.bazel"), `
+local_path_override(path = "third_party/dev", module_name = "dev")
+`)
+
+	want := map[string]string{
+		"lib":   filepath.Join(filepath.Dir(ws), "lib"),
+		"tools": "/opt/tools",
+		"dev":   filepath.Join(ws, "third_party", "dev"),
+		"fork":  filepath.Join(ws, "third_party", "fork (patched)"),
+	}
+	if got := LocalPathOverrides(ws); !reflect.DeepEqual(want, got) {
+		t.Errorf("LocalPathOverrides() = %v, want %v", got, want)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	ws := t.TempDir()
+	write(t, filepath.Join(ws, "MODULE.bazel"), `include("//third_party:js.MODULE.bazel")`)
+	write(t, filepath.Join(ws, "third_party", "js.MODULE.bazel"), ``)
+	write(t, filepath.Join(ws, "go.mod"), ``)
+
+	want := []string{
+		filepath.Join(ws, "MODULE.bazel"),
+		filepath.Join(ws, "go.mod"),
+		filepath.Join(ws, "third_party", "js.MODULE.bazel"),
+	}
+	if got := Files(ws, []string{"@@//:go.mod", "//:missing.txt", "//tools:not_a_file"}); !reflect.DeepEqual(want, got) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	output := `## @aspect_rules_js~~npm~npm:
+# <builtin>
+npm_translate_lock_rule(
+  name = "aspect_rules_js~~npm~npm",
+  pnpm_lock = "@@//:pnpm-lock.yaml",
+  patches = ["@@//patches:lodash.patch", "@@other~//:ignored.patch"],
+)
+`
+	want := []string{"@@//:pnpm-lock.yaml", "@@//patches:lodash.patch"}
+	if got := Labels(output); !reflect.DeepEqual(want, got) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+}
+
+func TestExtensionIDs(t *testing.T) {
+	graph := `{
+  "key": "<root>",
+  "extensionUsages": ["@@gazelle+//:extensions.bzl%go_deps"],
+  "dependencies": [{
+    "key": "rules_python@0.31.0",
+    "extensionUsages": ["@@rules_python+//python/extensions:pip.bzl%pip", "@@gazelle+//:extensions.bzl%go_deps"]
+  }]
+}`
+	got, err := ExtensionIDs([]byte(graph))
+	if err != nil {
+		t.Fatalf("ExtensionIDs(): %v", err)
+	}
+	want := []string{"@@gazelle+//:extensions.bzl%go_deps", "@@rules_python+//python/extensions:pip.bzl%pip"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("ExtensionIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestLocalRepositories(t *testing.T) {
+	ws := t.TempDir()
+	repos := `## @lib:
+# <builtin>
+local_repository(
+  name = "lib+",
+  path = "../lib",
+)
+
+## @rules_go:
+# <builtin>
+http_archive(
+  name = "rules_go+",
+  urls = ["https://example.com/rules_go.zip"],
+)
+`
+	want := map[string]string{"lib+": filepath.Join(filepath.Dir(ws), "lib")}
+	if got := LocalRepositories(ws, repos); !reflect.DeepEqual(want, got) {
+		t.Errorf("LocalRepositories() = %v, want %v", got, want)
+	}
+}
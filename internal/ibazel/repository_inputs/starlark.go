@@ -0,0 +1,159 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository_inputs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// token is a Starlark identifier, string literal or punctuation character.
+type token struct {
+	kind byte // 'i' for identifiers, 's' for strings, else the character
+	text string
+}
+
+// tokenize splits Starlark source into tokens, skipping comments. Numbers and
+// operators come out as single characters, which is enough to find calls.
+func tokenize(src string) []token {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			end := stringEnd(src, i)
+			tokens = append(tokens, token{'s', src[i:end]})
+			i = end
+		case isIdent(c):
+			start := i
+			for i < len(src) && isIdent(src[i]) {
+				i++
+			}
+			// String prefixes, as in r"..." or b'...'.
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') && i-start <= 2 && strings.Trim(src[start:i], "rRbB") == "" {
+				end := stringEnd(src, i)
+				tokens = append(tokens, token{'s', src[i:end]})
+				i = end
+				continue
+			}
+			tokens = append(tokens, token{'i', src[start:i]})
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		default:
+			tokens = append(tokens, token{c, string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// stringEnd returns the end of the string literal starting at src[start],
+// which may be triple-quoted.
+func stringEnd(src string, start int) int {
+	quote := src[start : start+1]
+	if strings.HasPrefix(src[start:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	for i := start + len(quote); i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case strings.HasPrefix(src[i:], quote):
+			return i + len(quote)
+		case src[i] == '\n' && len(quote) == 1:
+			return i
+		}
+	}
+	return len(src)
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// unquote returns the value of a string literal.
+func unquote(lit string) string {
+	if v, err := strconv.Unquote(lit); err == nil {
+		return v
+	}
+	quote := lit[:1]
+	if strings.HasPrefix(lit, strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(lit, quote), quote)
+}
+
+// calls returns the keyword arguments with a string literal value of every
+// call to the function name in src, such as {"module_name": "lib", "path":
+// "../lib"} for local_path_override(module_name = "lib", path = "../lib").
+// Parentheses, brackets and braces nest, so the arguments may contain calls
+// and lists, and strings and comments may contain any character.
+func calls(src, name string) []map[string]string {
+	tokens := tokenize(src)
+	var result []map[string]string
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].kind != 'i' || tokens[i].text != name || tokens[i+1].kind != '(' {
+			continue
+		}
+		// A method of the same name, as in foo.local_path_override(...).
+		if i > 0 && tokens[i-1].kind == '.' {
+			continue
+		}
+
+		args := map[string]string{}
+		depth := 0
+		j := i + 1
+		for ; j < len(tokens); j++ {
+			switch tokens[j].kind {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+			// Arguments start after the opening parenthesis or a comma.
+			if depth == 1 && (j == i+1 || tokens[j].kind == ',') {
+				if key, value, ok := keywordArg(tokens[j+1:]); ok {
+					args[key] = value
+				}
+			}
+		}
+		if depth != 0 {
+			// The call isn't closed, as in a file being edited.
+			break
+		}
+		result = append(result, args)
+		i = j
+	}
+	return result
+}
+
+// keywordArg returns the keyword argument tokens start with, if its value is a
+// single string literal.
+func keywordArg(tokens []token) (string, string, bool) {
+	if len(tokens) < 4 || tokens[0].kind != 'i' || tokens[1].kind != '=' || tokens[2].kind != 's' {
+		return "", "", false
+	}
+	if end := tokens[3].kind; end != ',' && end != ')' {
+		return "", "", false
+	}
+	return tokens[0].text, unquote(tokens[2].text), true
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository_inputs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCalls(t *testing.T) {
+	src := `
+f(a = "1", b = g("x", h()), c = "3")
+# f(a = "commented out")
+f(
+    a = "with ) and ( inside",  # a comment with )
+    b = ["[", ")"],
+    c = '''triple "quoted"''',
+    d = "a" + "b",
+)
+x.f(a = "method")
+not_f(a = "other function")
+f(a = "unterminated"
+`
+	want := []map[string]string{
+		{"a": "1", "c": "3"},
+		{"a": "with ) and ( inside", "c": `triple "quoted"`},
+	}
+	if got := calls(src, "f"); !reflect.DeepEqual(want, got) {
+		t.Errorf("calls() = %v, want %v", got, want)
+	}
+}
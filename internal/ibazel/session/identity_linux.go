@@ -0,0 +1,57 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processIdentity reads the start time of pid, in clock ticks since boot, from
+// field 22 of /proc/<pid>/stat, along with the id of the current boot.
+func processIdentity(pid int) (identity, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return identity{}, err
+	}
+	// The command name in field 2 is in parentheses and may contain spaces.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return identity{}, fmt.Errorf("unexpected contents of /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	const startTimeField = 22 - 3 // fields are counted from 1, after pid and comm
+	if len(fields) <= startTimeField {
+		return identity{}, fmt.Errorf("unexpected contents of /proc/%d/stat", pid)
+	}
+	id := identity{StartTime: fields[startTimeField]}
+	if _, err := strconv.ParseUint(id.StartTime, 10, 64); err != nil {
+		return identity{}, fmt.Errorf("unexpected start time in /proc/%d/stat: %q", pid, id.StartTime)
+	}
+
+	bootID, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return identity{}, err
+	}
+	id.BootID = strings.TrimSpace(string(bootID))
+
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		id.Cmdline = strings.ReplaceAll(strings.TrimRight(string(cmdline), "\x00"), "\x00", " ")
+	}
+	return id, nil
+}
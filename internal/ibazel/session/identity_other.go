@@ -0,0 +1,47 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// processIdentity asks ps for the start time of pid. It is a wall clock date,
+// which tells processes of different boots apart too.
+func processIdentity(pid int) (identity, error) {
+	startTime, err := ps(pid, "lstart=")
+	if err != nil {
+		return identity{}, err
+	}
+	if startTime == "" {
+		return identity{}, fmt.Errorf("no process %d", pid)
+	}
+	cmdline, _ := ps(pid, "command=")
+	return identity{StartTime: startTime, Cmdline: cmdline}, nil
+}
+
+func ps(pid int, format string) (string, error) {
+	cmd := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", format)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package session remembers which process iBazel is running for a target, so
+// that a later iBazel can find processes left behind when iBazel itself was
+// killed.
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State is what is recorded about a running target.
+type State struct {
+	// IBazelPID is the pid of the iBazel that started the process.
+	IBazelPID int `json:"ibazel_pid"`
+	// PGID is the id of the process group of the target.
+	PGID int `json:"pgid"`
+	// Workspace is the root of the workspace of the target.
+	Workspace string `json:"workspace"`
+	// Target is the label of the target.
+	Target string `json:"target"`
+	// The identity of the process group leader tells it apart from a process
+	// that reused its pid, for instance after a reboot.
+	identity
+}
+
+// identity identifies a process beyond its pid.
+type identity struct {
+	// StartTime is when the process started.
+	StartTime string `json:"start_time,omitempty"`
+	// BootID is the id of the boot the process started in, where the platform
+	// has one.
+	BootID string `json:"boot_id,omitempty"`
+	// Cmdline is the command line of the process when it was recorded. It is
+	// only informative, since run scripts exec the binary of the target.
+	Cmdline string `json:"cmdline,omitempty"`
+}
+
+// Session is the state file of a target in a workspace.
+type Session struct {
+	path      string
+	workspace string
+	target    string
+}
+
+// New returns the session of target in workspace. The state files live in the
+// cache directory of the user.
+func New(workspace string, target string) (*Session, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return newInDir(filepath.Join(cache, "ibazel", "sessions"), workspace, target), nil
+}
+
+func newInDir(dir string, workspace string, target string) *Session {
+	sum := sha256.Sum256([]byte(workspace + "\x00" + target))
+	return &Session{
+		path:      filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"),
+		workspace: workspace,
+		target:    target,
+	}
+}
+
+// Record remembers that this iBazel runs the target in process group pgid.
+func (s *Session) Record(pgid int) error {
+	id, err := processIdentity(pgid)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(State{
+		IBazelPID: os.Getpid(),
+		PGID:      pgid,
+		Workspace: s.workspace,
+		Target:    s.target,
+		identity:  id,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// Clear forgets the process recorded by this iBazel.
+func (s *Session) Clear() error {
+	state, err := s.read()
+	if err != nil || state.IBazelPID != os.Getpid() {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// Orphan returns the process group recorded for the target by an iBazel that
+// isn't running anymore, if that process group still is. It returns nil if
+// there is no such process group.
+func (s *Session) Orphan() (*State, error) {
+	state, err := s.read()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state.IBazelPID == os.Getpid() || processAlive(state.IBazelPID) {
+		return nil, nil
+	}
+	if !groupAlive(state.PGID) || !sameProcess(state) {
+		// Nothing was left behind, or the process group id has been reused by
+		// another program since.
+		os.Remove(s.path)
+		return nil, nil
+	}
+	return state, nil
+}
+
+// Terminate stops the processes of an orphaned process group and forgets
+// about them.
+func (s *Session) Terminate(state *State) error {
+	// Check again, the process may have exited while the user was asked.
+	if !sameProcess(state) {
+		os.Remove(s.path)
+		return fmt.Errorf("process group %d isn't the one iBazel started anymore", state.PGID)
+	}
+	if err := terminateGroup(state.PGID); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// sameProcess reports whether the leader of the process group of state is
+// still the process that was recorded. Its start time and boot tell it apart
+// from a later process with the same pid.
+func sameProcess(state *State) bool {
+	if state.StartTime == "" {
+		return false
+	}
+	id, err := processIdentity(state.PGID)
+	if err != nil {
+		return false
+	}
+	return id.StartTime == state.StartTime && id.BootID == state.BootID
+}
+
+func (s *Session) read() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
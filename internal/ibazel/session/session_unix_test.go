@@ -0,0 +1,156 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// deadPID returns the pid of a process that has exited.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func writeState(t *testing.T, s *Session, state State) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordAndClear(t *testing.T) {
+	s := newInDir(t.TempDir(), "/workspace", "//:target")
+
+	if err := s.Record(os.Getpid()); err != nil {
+		t.Fatalf("Record(): %v", err)
+	}
+	if state, err := s.read(); err != nil || state.StartTime == "" {
+		t.Errorf("Record() = %+v, %v, want the start time of the process", state, err)
+	}
+	// A process recorded by this iBazel is never an orphan.
+	if orphan, err := s.Orphan(); err != nil || orphan != nil {
+		t.Errorf("Orphan() = %+v, %v, want nil", orphan, err)
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatalf("Clear(): %v", err)
+	}
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("Clear() didn't remove the state file")
+	}
+}
+
+// startGroup starts a process in a process group of its own and returns its
+// identity.
+func startGroup(t *testing.T) (*exec.Cmd, identity) {
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := processIdentity(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd, id
+}
+
+func TestOrphan(t *testing.T) {
+	s := newInDir(t.TempDir(), "/workspace", "//:target")
+
+	cmd, id := startGroup(t)
+	writeState(t, s, State{IBazelPID: deadPID(t), PGID: cmd.Process.Pid, Workspace: "/workspace", Target: "//:target", identity: id})
+
+	orphan, err := s.Orphan()
+	if err != nil || orphan == nil {
+		t.Fatalf("Orphan() = %+v, %v, want the sleep process", orphan, err)
+	}
+	// Reap the process like init would for a real orphan.
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	if err := s.Terminate(orphan); err != nil {
+		t.Fatalf("Terminate(): %v", err)
+	}
+	if err := <-waited; err == nil {
+		t.Errorf("The orphaned process wasn't terminated")
+	}
+	if orphan, _ := s.Orphan(); orphan != nil {
+		t.Errorf("Orphan() after Terminate() = %+v, want nil", orphan)
+	}
+}
+
+func TestOrphan_GroupGone(t *testing.T) {
+	s := newInDir(t.TempDir(), "/workspace", "//:target")
+	writeState(t, s, State{IBazelPID: deadPID(t), PGID: deadPID(t)})
+
+	if orphan, err := s.Orphan(); err != nil || orphan != nil {
+		t.Errorf("Orphan() = %+v, %v, want nil", orphan, err)
+	}
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("Orphan() didn't remove the stale state file")
+	}
+}
+
+func TestOrphan_PIDReused(t *testing.T) {
+	s := newInDir(t.TempDir(), "/workspace", "//:target")
+
+	// The recorded process group was started before a reboot, and another
+	// program got its id since.
+	cmd, id := startGroup(t)
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	recorded := id
+	recorded.BootID = "previous-boot"
+	recorded.StartTime = "1"
+	state := State{IBazelPID: deadPID(t), PGID: cmd.Process.Pid, identity: recorded}
+	writeState(t, s, state)
+
+	if orphan, err := s.Orphan(); err != nil || orphan != nil {
+		t.Errorf("Orphan() = %+v, %v, want nil", orphan, err)
+	}
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Errorf("Orphan() didn't remove the stale state file")
+	}
+
+	// Terminate checks again before signalling.
+	writeState(t, s, state)
+	if err := s.Terminate(&state); err == nil {
+		t.Errorf("Terminate() should refuse to signal another program")
+	}
+	if err := syscall.Kill(cmd.Process.Pid, 0); err != nil {
+		t.Errorf("The other program was signalled: %v", err)
+	}
+
+	// State files of older iBazel versions can't be verified either.
+	writeState(t, s, State{IBazelPID: deadPID(t), PGID: cmd.Process.Pid})
+	if orphan, err := s.Orphan(); err != nil || orphan != nil {
+		t.Errorf("Orphan() without a start time = %+v, %v, want nil", orphan, err)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import "errors"
+
+// The processes of a target are in a job object on Windows, which can't be
+// found again once iBazel is gone, so orphans are never reported.
+
+func processAlive(pid int) bool {
+	return true
+}
+
+func groupAlive(pgid int) bool {
+	return false
+}
+
+func processIdentity(pid int) (identity, error) {
+	return identity{}, errors.New("not supported on Windows")
+}
+
+func terminateGroup(pgid int) error {
+	return errors.New("terminating orphaned processes is not supported on Windows")
+}
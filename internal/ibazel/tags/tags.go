@@ -0,0 +1,135 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tags reads the per-target configuration that a rule declares in its
+// tags attribute.
+package tags
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+const (
+	notifyChanges = "ibazel_notify_changes"
+	liveReload    = "ibazel_live_reload"
+	noRestart     = "ibazel_no_restart"
+	restartSignal = "ibazel_restart_signal"
+	gracePeriod   = "ibazel_grace_period"
+	readyPort     = "ibazel_ready_port"
+	debounce      = "ibazel_debounce"
+)
+
+// Config is the behavior a target asks for through its tags. Zero values mean
+// that the target didn't ask for anything and the global flags apply.
+type Config struct {
+	// NotifyChanges keeps the process alive and tells it about changes on stdin.
+	NotifyChanges bool
+	// LiveReload starts the live reload server.
+	LiveReload bool
+	// NoRestart disables crash restarts, overriding --run_restart_policy.
+	NoRestart bool
+	// RestartSignal is sent to stop the process, instead of SIGTERM.
+	RestartSignal syscall.Signal
+	// GracePeriod overrides --graceful_termination_wait_duration.
+	GracePeriod time.Duration
+	// ReadyPort overrides --run_ready_port.
+	ReadyPort int
+	// Debounce overrides --debounce.
+	Debounce time.Duration
+}
+
+// Parse returns the configuration declared by the tags of rule. Tags with an
+// invalid value are ignored and reported in the returned errors.
+func Parse(rule *blaze_query.Rule) (Config, []error) {
+	var c Config
+	var errs []error
+	if rule == nil {
+		return c, nil
+	}
+	for _, attr := range rule.Attribute {
+		if attr.GetName() == "tags" && attr.GetType() == blaze_query.Attribute_STRING_LIST {
+			for _, tag := range attr.StringListValue {
+				if err := c.parseTag(tag); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return c, errs
+}
+
+func (c *Config) parseTag(tag string) error {
+	name, value, hasValue := strings.Cut(tag, "=")
+	switch {
+	case name == notifyChanges && !hasValue:
+		c.NotifyChanges = true
+	case name == liveReload && !hasValue:
+		c.LiveReload = true
+	case name == noRestart && !hasValue:
+		c.NoRestart = true
+	case name == restartSignal && hasValue:
+		sig, ok := parseSignal(value)
+		if !ok {
+			return fmt.Errorf("ignoring tag %q: unknown signal", tag)
+		}
+		c.RestartSignal = sig
+	case name == gracePeriod && hasValue:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("ignoring tag %q: expected a positive duration such as 30s", tag)
+		}
+		c.GracePeriod = d
+	case name == readyPort && hasValue:
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("ignoring tag %q: expected a port number", tag)
+		}
+		c.ReadyPort = port
+	case name == debounce && hasValue:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("ignoring tag %q: expected a positive duration such as 500ms", tag)
+		}
+		c.Debounce = d
+	case strings.HasPrefix(name, "ibazel_"):
+		return fmt.Errorf("ignoring unknown tag %q", tag)
+	}
+	return nil
+}
+
+// signals are the signals that can be used as a restart signal on every
+// platform. Platform specific ones are added in init.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// parseSignal accepts signal names with or without the SIG prefix, in any case.
+func parseSignal(name string) (syscall.Signal, bool) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signals[name]
+	return sig, ok
+}
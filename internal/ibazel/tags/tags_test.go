@@ -0,0 +1,76 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tags
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+func ruleWithTags(tags ...string) *blaze_query.Rule {
+	return &blaze_query.Rule{
+		Name: proto.String("//:target"),
+		Attribute: []*blaze_query.Attribute{
+			{
+				Name:            proto.String("tags"),
+				Type:            blaze_query.Attribute_STRING_LIST.Enum(),
+				StringListValue: tags,
+			},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		tags    []string
+		want    Config
+		invalid bool
+	}{
+		{nil, Config{}, false},
+		{[]string{"manual", "ibazel_notify_changes"}, Config{NotifyChanges: true}, false},
+		{[]string{"ibazel_live_reload", "ibazel_no_restart"}, Config{LiveReload: true, NoRestart: true}, false},
+		{[]string{"ibazel_restart_signal=SIGINT"}, Config{RestartSignal: syscall.SIGINT}, false},
+		{[]string{"ibazel_restart_signal=hup"}, Config{RestartSignal: syscall.SIGHUP}, false},
+		{[]string{"ibazel_grace_period=30s"}, Config{GracePeriod: 30 * time.Second}, false},
+		{[]string{"ibazel_ready_port=8080"}, Config{ReadyPort: 8080}, false},
+		{[]string{"ibazel_debounce=500ms"}, Config{Debounce: 500 * time.Millisecond}, false},
+		// Invalid values are ignored.
+		{[]string{"ibazel_restart_signal=SIGNOPE"}, Config{}, true},
+		{[]string{"ibazel_grace_period=forever"}, Config{}, true},
+		{[]string{"ibazel_ready_port=http"}, Config{}, true},
+		{[]string{"ibazel_ready_port=70000"}, Config{}, true},
+		{[]string{"ibazel_debounce=-1s"}, Config{}, true},
+		{[]string{"ibazel_notify_changes=yes"}, Config{}, true},
+	} {
+		got, errs := Parse(ruleWithTags(c.tags...))
+		if got != c.want {
+			t.Errorf("Parse(%v) = %+v, want %+v", c.tags, got, c.want)
+		}
+		if invalid := len(errs) > 0; invalid != c.invalid {
+			t.Errorf("Parse(%v) errors = %v, want errors: %v", c.tags, errs, c.invalid)
+		}
+	}
+}
+
+func TestParse_NilRule(t *testing.T) {
+	if got, _ := Parse(nil); got != (Config{}) {
+		t.Errorf("Parse(nil) = %+v, want the zero Config", got)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vcs detects version control operations in progress, during which
+// the working tree is in flux.
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitOperations maps files that git keeps in its directory while an operation
+// is in progress to the name of the operation, most specific first.
+var gitOperations = []struct {
+	file string
+	name string
+	lock bool
+}{
+	{"rebase-merge", "rebase", false},
+	{"rebase-apply", "rebase", false},
+	{"MERGE_HEAD", "merge", false},
+	{"CHERRY_PICK_HEAD", "cherry-pick", false},
+	{"REVERT_HEAD", "revert", false},
+	// Held while git writes the index, most notably during a checkout.
+	{"index.lock", "checkout", true},
+}
+
+// FindGitDir returns the git directory of the repository that contains dir, or
+// "" if there is none. Worktrees and submodules, whose .git is a file pointing
+// to the actual git directory, are supported.
+func FindGitDir(dir string) string {
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			if info.IsDir() {
+				return dotGit
+			}
+			return readGitFile(dotGit)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readGitFile reads a .git file of the form "gitdir: <path>".
+func readGitFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return gitDir
+}
+
+// Op is a git operation in progress.
+type Op struct {
+	// Name is the name of the operation, such as "checkout" or "rebase".
+	Name string
+	// Path is the file git keeps in its directory until the operation finishes.
+	Path string
+	// Lock is true if Path is a lock file, which is left behind when git is
+	// killed in the middle of the operation.
+	Lock bool
+	// ModTime is the modification time of Path.
+	ModTime time.Time
+}
+
+// Current returns the git operation in progress in gitDir, if any.
+func Current(gitDir string) (Op, bool) {
+	if gitDir == "" {
+		return Op{}, false
+	}
+	for _, op := range gitOperations {
+		path := filepath.Join(gitDir, op.file)
+		if info, err := os.Stat(path); err == nil {
+			return Op{Name: op.name, Path: path, Lock: op.lock, ModTime: info.ModTime()}, true
+		}
+	}
+	return Op{}, false
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindGitDir(t *testing.T) {
+	root := t.TempDir()
+	gitDir := filepath.Join(root, ".git")
+	nested := filepath.Join(root, "a", "b")
+	for _, dir := range []string{gitDir, nested} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := FindGitDir(nested); got != gitDir {
+		t.Errorf("FindGitDir(%q) = %q, want %q", nested, got, gitDir)
+	}
+
+	// A worktree points to its git directory from a .git file.
+	worktree := filepath.Join(root, "worktree")
+	os.MkdirAll(worktree, 0755)
+	os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: ../.git/worktrees/wt\n"), 0644)
+	want := filepath.Join(root, ".git", "worktrees", "wt")
+	if got := FindGitDir(worktree); got != want {
+		t.Errorf("FindGitDir(%q) = %q, want %q", worktree, got, want)
+	}
+}
+
+func TestCurrent_Operations(t *testing.T) {
+	gitDir := t.TempDir()
+	if op, ok := Current(""); ok {
+		t.Errorf("Current() outside of a repository = %+v, want none", op)
+	}
+
+	for _, c := range []struct {
+		file string
+		dir  bool
+		want string
+	}{
+		{"index.lock", false, "checkout"},
+		{"MERGE_HEAD", false, "merge"},
+		{"rebase-merge", true, "rebase"},
+	} {
+		path := filepath.Join(gitDir, c.file)
+		if c.dir {
+			os.Mkdir(path, 0755)
+		} else {
+			os.WriteFile(path, nil, 0644)
+		}
+		if op, _ := Current(gitDir); op.Name != c.want {
+			t.Errorf("Current() with %s = %q, want %q", c.file, op.Name, c.want)
+		}
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	gitDir := t.TempDir()
+	if _, ok := Current(gitDir); ok {
+		t.Errorf("Current() without an operation should report none")
+	}
+
+	lock := filepath.Join(gitDir, "index.lock")
+	os.WriteFile(lock, nil, 0644)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(lock, old, old)
+	op, ok := Current(gitDir)
+	if !ok || op.Path != lock || !op.Lock || !op.ModTime.Equal(old) {
+		t.Errorf("Current() with index.lock = %+v, %v, want a lock at %s modified at %v", op, ok, lock, old)
+	}
+
+	os.WriteFile(filepath.Join(gitDir, "MERGE_HEAD"), nil, 0644)
+	if op, _ := Current(gitDir); op.Name != "merge" || op.Lock {
+		t.Errorf("Current() with MERGE_HEAD = %+v, want a merge that isn't a lock", op)
+	}
+}
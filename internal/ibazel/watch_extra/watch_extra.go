@@ -0,0 +1,245 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watch_extra watches files that aren't inputs of the build graph,
+// such as runtime configuration, and decides what a change to them triggers.
+package watch_extra
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Actions that a change to an extra file can trigger.
+const (
+	// Restart restarts the run target without rebuilding it.
+	Restart = "restart"
+	// Rebuild rebuilds and restarts the targets.
+	Rebuild = "rebuild"
+	// Requery queries the build graph again before rebuilding.
+	Requery = "requery"
+	// Hook runs Command.
+	Hook = "hook"
+)
+
+// ConfigFile is the file in the workspace root that lists extra files to
+// watch, in addition to --watch_extra.
+const ConfigFile = ".ibazel_watch_extra.json"
+
+// Rule matches extra files to watch with a glob. Relative globs are relative to
+// the workspace root and "**" matches any number of directories.
+type Rule struct {
+	Glob    string `json:"glob"`
+	Action  string `json:"action"`
+	Command string `json:"command"`
+
+	pattern *regexp.Regexp
+}
+
+// ParseFlag parses the value of --watch_extra, which is a glob optionally
+// followed by "=" and an action. The command of a hook follows "hook:".
+func ParseFlag(value string) (Rule, error) {
+	r := Rule{Glob: value}
+	if i := strings.Index(value, "="); i >= 0 {
+		r.Glob = value[:i]
+		r.Action = value[i+1:]
+		if command, ok := strings.CutPrefix(r.Action, Hook+":"); ok {
+			r.Action = Hook
+			r.Command = command
+		}
+	}
+	if err := r.validate(); err != nil {
+		return Rule{}, fmt.Errorf("--watch_extra=%s: %w", value, err)
+	}
+	return r, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Glob == "" {
+		return fmt.Errorf("missing glob")
+	}
+	switch r.Action {
+	case "":
+		r.Action = Restart
+	case Restart, Rebuild, Requery:
+	case Hook:
+		if strings.TrimSpace(r.Command) == "" {
+			return fmt.Errorf("hook without a command")
+		}
+	default:
+		return fmt.Errorf("unknown action %q, expected %s, %s, %s or %s", r.Action, Restart, Rebuild, Requery, Hook)
+	}
+	return nil
+}
+
+// LoadConfig reads the rules in the ConfigFile of workspace, if there is one.
+// Changes to the file itself requery, which picks up the new rules.
+func LoadConfig(workspace string) ([]Rule, error) {
+	path := filepath.Join(workspace, ConfigFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+		}
+	}
+	return append(rules, Rule{Glob: path, Action: Requery}), nil
+}
+
+// Resolve makes the globs of rules absolute, relative to root, and prepares
+// them for matching.
+func Resolve(rules []Rule, root string) ([]Rule, error) {
+	resolved := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if !filepath.IsAbs(r.Glob) {
+			r.Glob = filepath.Join(root, r.Glob)
+		}
+		r.Glob = filepath.Clean(r.Glob)
+		pattern, err := regexp.Compile("^" + globExpr(filepath.ToSlash(r.Glob)) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", r.Glob, err)
+		}
+		r.pattern = pattern
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// globExpr translates a glob into a regular expression.
+func globExpr(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				expr.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Matches reports whether path matches the glob of r. r must come from
+// Resolve.
+func (r Rule) Matches(path string) bool {
+	return r.pattern != nil && r.pattern.MatchString(filepath.ToSlash(path))
+}
+
+// Match returns the first rule that matches path.
+func Match(rules []Rule, path string) (Rule, bool) {
+	for _, r := range rules {
+		if r.Matches(path) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Dirs returns the directories to watch to notice changes to files matching
+// rules, including files that don't exist yet. rules must come from Resolve.
+func Dirs(rules []Rule) []string {
+	dirs := map[string]struct{}{}
+	for _, r := range rules {
+		base, rest := splitGlob(r.Glob)
+		if rest == "" {
+			// A plain path.
+			dirs[filepath.Dir(base)] = struct{}{}
+			continue
+		}
+		dirPattern := regexp.MustCompile("^" + globExpr(filepath.ToSlash(filepath.Join(base, filepath.Dir(rest)))) + "$")
+		maxDepth := -1
+		if !strings.Contains(rest, "**") {
+			maxDepth = strings.Count(filepath.ToSlash(rest), "/")
+		}
+		filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if dirPattern.MatchString(filepath.ToSlash(path)) || (path == base && strings.HasPrefix(rest, "**")) {
+				dirs[path] = struct{}{}
+			}
+			if maxDepth >= 0 && depth(base, path) >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	return list
+}
+
+// splitGlob splits glob into the directory before the first component with a
+// wildcard and the rest. rest is empty if glob has no wildcards.
+func splitGlob(glob string) (base string, rest string) {
+	parts := strings.Split(glob, string(filepath.Separator))
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			base = strings.Join(parts[:i], string(filepath.Separator))
+			if base == "" {
+				base = string(filepath.Separator)
+			}
+			return base, strings.Join(parts[i:], string(filepath.Separator))
+		}
+	}
+	return glob, ""
+}
+
+func depth(base, path string) int {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
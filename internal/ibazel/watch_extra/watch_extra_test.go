@@ -0,0 +1,138 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_extra
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseFlag(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  Rule
+	}{
+		{"config/*.json", Rule{Glob: "config/*.json", Action: Restart}},
+		{"certs/**=rebuild", Rule{Glob: "certs/**", Action: Rebuild}},
+		{"deps.txt=requery", Rule{Glob: "deps.txt", Action: Requery}},
+		{"flags.json=hook:make flags", Rule{Glob: "flags.json", Action: Hook, Command: "make flags"}},
+	} {
+		got, err := ParseFlag(c.value)
+		if err != nil {
+			t.Errorf("ParseFlag(%q): %v", c.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseFlag(%q) = %+v, want %+v", c.value, got, c.want)
+		}
+	}
+
+	for _, value := range []string{"", "=restart", "a.json=reload", "a.json=hook:"} {
+		if _, err := ParseFlag(value); err == nil {
+			t.Errorf("ParseFlag(%q) should have failed", value)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	root := filepath.FromSlash("/ws")
+	rules, err := Resolve([]Rule{
+		{Glob: "config/*.json", Action: Restart},
+		{Glob: "certs/**/*.pem", Action: Rebuild},
+		{Glob: "/etc/app/flag?.[!b]", Action: Requery},
+	}, root)
+	if err != nil {
+		t.Fatalf("Resolve(): %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"/ws/config/app.json":        Restart,
+		"/ws/config/nested/app.json": "",
+		"/ws/config/app.yaml":        "",
+		"/ws/certs/ca.pem":           Rebuild,
+		"/ws/certs/a/b/server.pem":   Rebuild,
+		"/etc/app/flag1.a":           Requery,
+		"/etc/app/flag1.b":           "",
+	} {
+		rule, _ := Match(rules, filepath.FromSlash(path))
+		if rule.Action != want {
+			t.Errorf("Match(%q) = %q, want %q", path, rule.Action, want)
+		}
+	}
+}
+
+func TestDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"config/nested", "certs/a/b", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := Resolve([]Rule{
+		{Glob: "config/*.json"},
+		{Glob: "certs/**/*.pem"},
+		{Glob: "VERSION"},
+	}, root)
+	if err != nil {
+		t.Fatalf("Resolve(): %v", err)
+	}
+
+	got := Dirs(rules)
+	sort.Strings(got)
+	want := []string{
+		root,
+		filepath.Join(root, "certs"),
+		filepath.Join(root, "certs", "a"),
+		filepath.Join(root, "certs", "a", "b"),
+		filepath.Join(root, "config"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Dirs() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	root := t.TempDir()
+	if rules, err := LoadConfig(root); err != nil || rules != nil {
+		t.Errorf("LoadConfig() without a config = %v, %v, want nil, nil", rules, err)
+	}
+
+	config := filepath.Join(root, ConfigFile)
+	os.WriteFile(config, []byte(`[
+		{"glob": "config/*.json"},
+		{"glob": "schema.sql", "action": "hook", "command": "make migrate"}
+	]`), 0644)
+	rules, err := LoadConfig(root)
+	if err != nil {
+		t.Fatalf("LoadConfig(): %v", err)
+	}
+	want := []Rule{
+		{Glob: "config/*.json", Action: Restart},
+		{Glob: "schema.sql", Action: Hook, Command: "make migrate"},
+		{Glob: config, Action: Requery},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("LoadConfig() = %+v, want %+v", rules, want)
+	}
+
+	os.WriteFile(config, []byte(`[{"glob": "a", "action": "explode"}]`), 0644)
+	if _, err := LoadConfig(root); err == nil {
+		t.Errorf("LoadConfig() with an unknown action should have failed")
+	}
+}
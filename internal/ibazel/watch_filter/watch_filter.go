@@ -0,0 +1,121 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watch_filter restricts the files iBazel watches to the packages
+// matched by label patterns, such as //src/... or //third_party/....
+package watch_filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pattern is a parsed label pattern.
+type pattern struct {
+	repo string
+	pkg  string
+	// recursive patterns end with /... and match subpackages too.
+	recursive bool
+	// name is the file matched in pkg, or "" for all of them.
+	name string
+}
+
+// Filter decides which labels are watched.
+type Filter struct {
+	only    []pattern
+	exclude []pattern
+}
+
+// New returns a filter that watches the labels matched by one of the only
+// patterns, or all labels if there are none, unless an exclude pattern matches
+// them too. Patterns are labels in the main repository or in an external one,
+// where //pkg/... matches pkg and its subpackages, and //pkg, //pkg:all or
+// //pkg:* the files of pkg.
+func New(only, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.only, err = parse(only); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parse(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Empty reports whether f lets every label through.
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.only) == 0 && len(f.exclude) == 0
+}
+
+// Watched reports whether the file with the given label is watched.
+func (f *Filter) Watched(label string) bool {
+	if f.Empty() {
+		return true
+	}
+	repo, pkg, name := split(label)
+	if len(f.only) > 0 && !matchAny(f.only, repo, pkg, name) {
+		return false
+	}
+	return !matchAny(f.exclude, repo, pkg, name)
+}
+
+func parse(patterns []string) ([]pattern, error) {
+	var parsed []pattern
+	for _, p := range patterns {
+		repo, rest, ok := strings.Cut(strings.TrimLeft(p, "@"), "//")
+		if !ok {
+			return nil, fmt.Errorf("invalid label pattern %q, expected //package/... or //package:name", p)
+		}
+		pat := pattern{repo: repo}
+		if rest == "..." || strings.HasSuffix(rest, "/...") {
+			pat.pkg = strings.TrimSuffix(strings.TrimSuffix(rest, "..."), "/")
+			pat.recursive = true
+		} else {
+			pat.pkg, pat.name, _ = strings.Cut(rest, ":")
+			switch pat.name {
+			case "all", "*", "all-targets":
+				pat.name = ""
+			}
+		}
+		parsed = append(parsed, pat)
+	}
+	return parsed, nil
+}
+
+// split returns the repository, package and name of label.
+func split(label string) (string, string, string) {
+	repo, rest, ok := strings.Cut(strings.TrimLeft(label, "@"), "//")
+	if !ok {
+		rest = label
+	}
+	pkg, name, _ := strings.Cut(rest, ":")
+	return repo, pkg, name
+}
+
+func matchAny(patterns []pattern, repo, pkg, name string) bool {
+	for _, p := range patterns {
+		if p.repo != repo {
+			continue
+		}
+		if p.recursive {
+			if p.pkg == "" || pkg == p.pkg || strings.HasPrefix(pkg, p.pkg+"/") {
+				return true
+			}
+		} else if pkg == p.pkg && (p.name == "" || name == p.name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_filter
+
+import "testing"
+
+func TestWatched(t *testing.T) {
+	f, err := New(
+		[]string{"//src/...", "//lib:all", "//:MODULE.bazel", "@dep//..."},
+		[]string{"//src/vendor/...", "@@dep//testdata:big.json"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, want := range map[string]bool{
+		"//src:main.go":           true,
+		"//src/app/api:server.go": true,
+		"//source:main.go":        false,
+		"//src/vendor:x.go":       false,
+		"//src/vendor/y/z:y.go":   false,
+		"//lib:lib.go":            true,
+		"//lib/sub:lib.go":        false,
+		"//:MODULE.bazel":         true,
+		"//:BUILD.bazel":          false,
+		"@@//src:main.go":         true,
+		"@dep//pkg:dep.go":        true,
+		"@dep//testdata:big.json": false,
+		"@other//src:main.go":     false,
+		"//third_party/x:BUILD":   false,
+	} {
+		if got := f.Watched(label); got != want {
+			t.Errorf("Watched(%q) = %v, want %v", label, got, want)
+		}
+	}
+}
+
+func TestWatched_excludeOnly(t *testing.T) {
+	f, err := New(nil, []string{"//third_party/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Watched("//src:main.go") {
+		t.Errorf("Expected //src:main.go to be watched")
+	}
+	if f.Watched("//third_party/go:x.go") {
+		t.Errorf("Expected //third_party/go:x.go not to be watched")
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var f *Filter
+	if !f.Empty() || !f.Watched("//any:thing") {
+		t.Errorf("Expected a nil filter to watch everything")
+	}
+	if _, err := New([]string{"src/..."}, nil); err == nil {
+		t.Errorf("Expected an error for a pattern without //")
+	}
+}
@@ -95,10 +95,13 @@ func (m *MainWorkspace) ExecuteCommand(command string, args []string) {
 	}
 }
 
-type FakeWorkspace struct{}
+type FakeWorkspace struct {
+	// Path is returned as the workspace root.
+	Path string
+}
 
 func (f *FakeWorkspace) FindWorkspace() (string, error) {
-	return "", nil
+	return f.Path, nil
 }
 
 func (f *FakeWorkspace) ExecuteCommand(command string, args []string) {}